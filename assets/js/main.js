@@ -38,34 +38,218 @@ input.addEventListener('change', function (e) {
 });
 
 // Checkbox handling
-var checkboxes = document.querySelectorAll('.downloadBulkCheckbox');
+function checkboxes() {
+  return document.querySelectorAll('.downloadBulkCheckbox');
+}
 
-Array.prototype.forEach.call(checkboxes, function (cb) {
-  cb.addEventListener('change', function () {
-    checkedBoxes = document.querySelectorAll('input[type=checkbox]:checked')
-      .length;
-    if (checkedBoxes >= 1) {
-      document.getElementById('downloadBulkButton').style.display = 'block';
-    } else {
-      document.getElementById('downloadBulkButton').style.display = 'none';
-    }
+function showBulkButtons(show) {
+  var buttons = document.querySelectorAll('.bulkButton');
+  Array.prototype.forEach.call(buttons, function (b) {
+    b.style.display = show ? 'inline-block' : 'none';
   });
+}
+
+// Listen on the table as rows are replaced when the directory changes
+document.getElementById('tableData').addEventListener('change', function (e) {
+  if (e.target.classList.contains('downloadBulkCheckbox')) {
+    checkedBoxes = document.querySelectorAll('.downloadBulkCheckbox:checked')
+      .length;
+    showBulkButtons(checkedBoxes >= 1);
+  }
 });
 
 function selectAll() {
-  Array.prototype.forEach.call(checkboxes, function (cb) {
+  Array.prototype.forEach.call(checkboxes(), function (cb) {
     cb.checked = true;
   });
-  document.getElementById('downloadBulkButton').style.display = 'block';
+  showBulkButtons(true);
 }
 
 function selectNone() {
-  Array.prototype.forEach.call(checkboxes, function (cb) {
+  Array.prototype.forEach.call(checkboxes(), function (cb) {
     cb.checked = false;
   });
-  document.getElementById('downloadBulkButton').style.display = 'none';
+  showBulkButtons(false);
+}
+
+// File management
+var fileOpsURL =
+  '/7079731d08e33a51e7987270bebb5dcf6e128e016c43cba52b1af91bd195ab86/';
+
+function fileOp(op, params) {
+  fetch(fileOpsURL + op, {
+    method: 'POST',
+    headers: { Accept: 'application/json', 'X-Requested-With': 'XMLHttpRequest' },
+    body: params,
+  })
+    .then(function (r) {
+      return r.json();
+    })
+    .then(function (res) {
+      if (res['error']) {
+        alert(res['error']);
+      } else {
+        location.reload();
+      }
+    })
+    .catch(function (e) {
+      alert('Error: ' + e);
+    });
+}
+
+function deleteFile(el) {
+  var file = el.getAttribute('data-path');
+  if (confirm('Are you sure you want to delete ' + file + '?')) {
+    var params = new URLSearchParams();
+    params.append('file', file);
+    fileOp('delete', params);
+  }
+  return false;
+}
+
+function deleteSelected() {
+  var params = new URLSearchParams();
+  var checked = document.querySelectorAll('.downloadBulkCheckbox:checked');
+  Array.prototype.forEach.call(checked, function (cb) {
+    params.append('file', decodeURIComponent(cb.value));
+  });
+  if (confirm('Are you sure you want to delete ' + checked.length + ' item(s)?')) {
+    fileOp('delete', params);
+  }
+}
+
+function renameFile(el) {
+  var file = el.getAttribute('data-path');
+  var to = prompt(
+    'New name, or a full path like /dir/name to move it:',
+    file.split('/').pop()
+  );
+  if (to) {
+    var params = new URLSearchParams();
+    params.append('from', file);
+    params.append('to', to);
+    fileOp('rename', params);
+  }
+  return false;
+}
+
+// Share links
+var shareURL =
+  '/c3bc45ac352fe43ff8f0a1cc26d6cc29f71f536dc417906f8513ea44ed4bb161/';
+
+function shareOp(op, params) {
+  return fetch(shareURL + op, {
+    method: params ? 'POST' : 'GET',
+    headers: { Accept: 'application/json', 'X-Requested-With': 'XMLHttpRequest' },
+    body: params,
+  })
+    .then(function (r) {
+      return r.json();
+    })
+    .then(function (res) {
+      if (res['error']) {
+        throw res['error'];
+      }
+      return res;
+    });
+}
+
+function shareFile(el) {
+  var file = el.getAttribute('data-path');
+  var expires = prompt('Share ' + file + '\nExpires after (like 30m, 24h, empty for never):', '24h');
+  if (expires === null) {
+    return false;
+  }
+  var downloads = prompt('Maximum downloads (0 for unlimited):', '1');
+  if (downloads === null) {
+    return false;
+  }
+  var password = prompt('Password (empty for none):', '');
+  if (password === null) {
+    return false;
+  }
+
+  var params = new URLSearchParams();
+  params.append('path', file);
+  params.append('expires', expires);
+  params.append('downloads', downloads);
+  params.append('password', password);
+  shareOp('create', params)
+    .then(function (link) {
+      prompt('Share link:', link['url']);
+      loadShares();
+    })
+    .catch(function (e) {
+      alert(e);
+    });
+  return false;
 }
 
+function revokeShare(token) {
+  if (confirm('Are you sure you want to revoke this link?')) {
+    var params = new URLSearchParams();
+    params.append('token', token);
+    shareOp('revoke', params)
+      .then(loadShares)
+      .catch(function (e) {
+        alert(e);
+      });
+  }
+  return false;
+}
+
+function loadShares() {
+  shareOp('list')
+    .then(function (links) {
+      var body = document.getElementById('shareLinks');
+      while (body.firstChild) {
+        body.removeChild(body.firstChild);
+      }
+      links.forEach(function (link) {
+        var tr = element('tr');
+        tr.appendChild(
+          element('td', '', link['path'] + (link['has_password'] ? ' (password)' : ''))
+        );
+        tr.appendChild(
+          element(
+            'td',
+            '',
+            link['expires'] ? new Date(link['expires']).toLocaleString() : 'never'
+          )
+        );
+        tr.appendChild(
+          element(
+            'td',
+            '',
+            link['downloads'] + ' / ' + (link['max_downloads'] || '\u221e')
+          )
+        );
+        var actions = element('td');
+        actions.appendChild(
+          iconLink('fa-link', function () {
+            prompt('Share link:', link['url']);
+            return false;
+          })
+        );
+        actions.appendChild(document.createTextNode(' '));
+        actions.appendChild(
+          iconLink('fa-trash', function () {
+            return revokeShare(link['token']);
+          })
+        );
+        tr.appendChild(actions);
+        body.appendChild(tr);
+      });
+      document.getElementById('shareLinksRow').style.display =
+        links.length > 0 ? 'flex' : 'none';
+    })
+    .catch(function (e) {
+      console.log('Error loading share links: ', e);
+    });
+}
+
+loadShares();
+
 // Everything related to websockets
 var wsURL =
   'ws://' +
@@ -75,6 +259,13 @@ var connection = new WebSocket(wsURL);
 
 connection.onopen = function () {
   console.log('Connected via WebSockets');
+  // Get told about changes in this directory
+  connection.send(
+    JSON.stringify({
+      type: 'subscribe',
+      content: decodeURIComponent(location.pathname),
+    })
+  );
 };
 
 connection.onclose = function () {
@@ -86,20 +277,404 @@ connection.onerror = function (e) {
 };
 
 connection.onmessage = function (m) {
-  try {
-    var message = JSON.parse(m.data);
-    if (message['type'] == 'refreshClipboard') {
-      location.reload();
+  // Several messages might be sent at once, one per line
+  m.data.split('\n').forEach(function (data) {
+    try {
+      handleMessage(JSON.parse(data));
+    } catch (e) {
+      console.log('Error reading message: ', e);
     }
-  } catch (e) {
-    console.log('Error reading message: ', e);
-  }
+  });
 };
 
+function handleMessage(message) {
+  switch (message['type']) {
+    case 'fullSnapshot':
+      clearCards();
+      (message['entries'] || []).forEach(function (entry) {
+        cards().appendChild(createCard(entry));
+      });
+      break;
+    case 'entryAdded':
+      cards().appendChild(createCard(message['entry']));
+      break;
+    case 'entryUpdated':
+      var old = document.getElementById('card-' + message['entry']['ID']);
+      if (old) {
+        old.replaceWith(createCard(message['entry']));
+      }
+      break;
+    case 'entryDeleted':
+      var card = document.getElementById('card-' + message['entry']['ID']);
+      if (card) {
+        card.remove();
+      }
+      break;
+    case 'cleared':
+      clearCards();
+      break;
+    case 'dirChanged':
+      dirChanged(message);
+      break;
+    case 'error':
+      alert(message['content']);
+      break;
+  }
+}
+
+// Directory listing
+var listingTimer;
+var changesTimer;
+var pendingChanges = {};
+
+// dirChanged will apply a change of the directory to its row. Many changes at once
+// (like a running upload) are collected for a moment, unknown ones reload the listing.
+function dirChanged(change) {
+  if (
+    ['add', 'remove', 'modify'].indexOf(change['op']) < 0 ||
+    !change['name']
+  ) {
+    refreshListing();
+    return;
+  }
+  // Only the last change of a file matters
+  pendingChanges[change['name']] = change;
+  if (!changesTimer) {
+    changesTimer = setTimeout(applyChanges, 300);
+  }
+}
+
+// applyChanges will replace, add or drop the rows of the collected changes
+function applyChanges() {
+  var changes = pendingChanges;
+  pendingChanges = {};
+  changesTimer = null;
+
+  var table = $('#tableData').DataTable();
+  Object.keys(changes).forEach(function (name) {
+    var change = changes[name];
+    var upath = (change['path'] === '/' ? '' : change['path']) + '/' + name;
+    var checked = false;
+    var old = findRow(table, upath);
+    if (old) {
+      var cb = old.querySelector('.downloadBulkCheckbox');
+      checked = cb && cb.checked;
+      table.row(old).remove();
+    }
+    // A file gone by now only loses its row
+    if (change['op'] !== 'remove' && change['file']) {
+      var tr = createRow(upath, name, change['file']);
+      tr.querySelector('.downloadBulkCheckbox').checked = checked;
+      table.row.add(tr);
+    }
+  });
+  table.draw(false);
+  showBulkButtons(
+    document.querySelectorAll('.downloadBulkCheckbox:checked').length >= 1
+  );
+}
+
+// findRow will return the row of the file at upath, rows hidden by the search included
+function findRow(table, upath) {
+  var rows = table.rows().nodes().toArray();
+  for (var i = 0; i < rows.length; i++) {
+    var link = rows[i].querySelector('[data-path]');
+    if (link && link.getAttribute('data-path') === upath) {
+      return rows[i];
+    }
+  }
+  return null;
+}
+
+// fileIcons are the icons the template shows for the file extensions
+var fileIcons = [
+  ['fas fa-file-archive', ['.gz', '.zip', '.tar', '.rar', '.7z']],
+  ['fas fa-file-pdf', ['.pdf']],
+  ['fas fa-file-powerpoint', ['.pptx', '.ppt', '.pps', '.odp']],
+  ['fas fa-file-word', ['.docx', '.doc', '.odt']],
+  ['fas fa-file-excel', ['.xlsx', '.xls', '.ods']],
+  ['fas fa-file-csv', ['.csv']],
+  ['fab fa-windows', ['.exe']],
+  [
+    'fas fa-file-alt',
+    [
+      '.txt',
+      '.rtf',
+      '.md',
+      '.conf',
+      '.html',
+      '.htm',
+      '.log',
+      '.ini',
+      '.cfg',
+      '.yml',
+      '.toml',
+      '.json',
+      '.asc',
+      '.xml',
+    ],
+  ],
+  ['fas fa-file-audio', ['.flac', '.wav', '.mp3']],
+  [
+    'fas fa-file-video',
+    [
+      '.mpeg',
+      '.mpg',
+      '.mp4',
+      '.wmv',
+      '.avi',
+      '.flv',
+      '.mkv',
+      '.mov',
+      '.webm',
+      '.vob',
+      '.ogg',
+      '.m4v',
+      '.h264',
+    ],
+  ],
+  [
+    'fas fa-file-image',
+    [
+      '.bmp',
+      '.tiff',
+      '.tif',
+      '.ai',
+      '.cdr',
+      '.xcf',
+      '.raw',
+      '.gif',
+      '.png',
+      '.jpg',
+      '.jpeg',
+      '.psd',
+      '.svg',
+      '.ico',
+    ],
+  ],
+  [
+    'fas fa-file-code',
+    [
+      '.php',
+      '.py',
+      '.go',
+      '.cs',
+      '.c',
+      '.pl',
+      '.rb',
+      '.cgi',
+      '.cpp',
+      '.java',
+      '.tex',
+      '.bat',
+      '.ps1',
+    ],
+  ],
+  [
+    'fas fa-compact-disc',
+    ['.iso', '.dmg', '.bin', '.vcd', '.vhd', '.vhdx', '.vmdk', '.qcow2', '.img'],
+  ],
+  ['fas fa-database', ['.mdb', '.db', '.dbf', '.dat', '.sql']],
+];
+
+function fileIcon(name, file) {
+  if (file['is_dir']) {
+    return 'fas fa-folder';
+  }
+  if (file['is_symlink']) {
+    return 'fas fa-file';
+  }
+  var ext = '.' + name.split('.').pop().toLowerCase();
+  for (var i = 0; i < fileIcons.length; i++) {
+    if (fileIcons[i][1].indexOf(ext) >= 0) {
+      return fileIcons[i][0];
+    }
+  }
+  return 'fas fa-file';
+}
+
+function actionLink(icon, href, onclick, upath) {
+  var a = element('a');
+  a.href = href;
+  if (onclick) {
+    a.onclick = function () {
+      return onclick(this);
+    };
+    a.setAttribute('data-path', upath);
+  }
+  a.appendChild(element('i', 'fas ' + icon + ' fa-1x'));
+  return a;
+}
+
+// createRow will build the same row the template renders
+function createRow(upath, name, file) {
+  var uri = encodeURIComponent(upath);
+  var tr = element('tr');
+
+  var check = element('td');
+  var chkbx = element('div', 'chkbx');
+  var cb = element('input', 'checkbox downloadBulkCheckbox');
+  cb.type = 'checkbox';
+  cb.name = 'file';
+  cb.value = uri;
+  chkbx.appendChild(cb);
+  check.appendChild(chkbx);
+  tr.appendChild(check);
+
+  var icon = element('td');
+  icon.appendChild(element('i', fileIcon(name, file) + ' file_ic'));
+  tr.appendChild(icon);
+
+  var label = file['is_dir'] ? name + '/' : name;
+  if (file['is_symlink']) {
+    label += ' --> ' + file['symlink_target'];
+  }
+  var nameCell = element('td');
+  var link = element('a', '', label);
+  link.href = '/' + uri;
+  nameCell.appendChild(link);
+  tr.appendChild(nameCell);
+
+  var size = element('td', '', file['is_dir'] ? '--' : file['display_size']);
+  size.setAttribute('data-order', file['size']);
+  tr.appendChild(size);
+
+  var modified = element('td', '', file['display_mtime']);
+  modified.setAttribute('data-order', file['mtime']);
+  tr.appendChild(modified);
+
+  var actions = element('td');
+  if (!file['is_dir']) {
+    actions.appendChild(actionLink('fa-download', uri + '?download'));
+    actions.appendChild(document.createTextNode(' '));
+  }
+  actions.appendChild(actionLink('fa-share-alt', '#', shareFile, upath));
+  // The delete button is only rendered if destructive operations are allowed
+  if (document.getElementById('deleteBulkButton')) {
+    actions.appendChild(document.createTextNode(' '));
+    actions.appendChild(actionLink('fa-edit', '#', renameFile, upath));
+    actions.appendChild(document.createTextNode(' '));
+    actions.appendChild(actionLink('fa-trash', '#', deleteFile, upath));
+  }
+  tr.appendChild(actions);
+
+  return tr;
+}
+
+// refreshListing will reload the rows of the table, many reloads at once
+// are collected for a moment
+function refreshListing() {
+  clearTimeout(listingTimer);
+  listingTimer = setTimeout(function () {
+    fetch(location.pathname)
+      .then(function (r) {
+        return r.text();
+      })
+      .then(function (html) {
+        var doc = new DOMParser().parseFromString(html, 'text/html');
+        var rows = doc.querySelectorAll('#tableData tbody tr');
+        var checked = {};
+        document
+          .querySelectorAll('.downloadBulkCheckbox:checked')
+          .forEach(function (cb) {
+            checked[cb.value] = true;
+          });
+
+        var table = $('#tableData').DataTable();
+        table.clear();
+        rows.forEach(function (row) {
+          var tr = document.importNode(row, true);
+          var cb = tr.querySelector('.downloadBulkCheckbox');
+          if (cb && checked[cb.value]) {
+            cb.checked = true;
+          }
+          table.row.add(tr);
+        });
+        table.draw(false);
+        showBulkButtons(
+          document.querySelectorAll('.downloadBulkCheckbox:checked').length >= 1
+        );
+      })
+      .catch(function (e) {
+        console.log('Error refreshing listing: ', e);
+      });
+  }, 300);
+}
+
+// Clipboard cards
+function cards() {
+  return document.getElementById('clipboardCards');
+}
+
+function clearCards() {
+  var c = cards();
+  while (c.firstChild) {
+    c.removeChild(c.firstChild);
+  }
+}
+
+function element(tag, className, text) {
+  var el = document.createElement(tag);
+  if (className) {
+    el.className = className;
+  }
+  if (text !== undefined) {
+    el.textContent = text;
+  }
+  return el;
+}
+
+function iconLink(icon, onclick) {
+  var a = element('a');
+  a.href = '#';
+  a.onclick = onclick;
+  a.appendChild(element('i', 'fas ' + icon));
+  return a;
+}
+
+// createCard will build the same card the template renders
+function createCard(entry) {
+  var card = element('div', 'card clipboardCard mt-2');
+  card.id = 'card-' + entry['ID'];
+
+  var header = element('div', 'card-header d-flex flex-row');
+  var title = element('div', 'col-md-10');
+  title.appendChild(element('h5', 'card-title', entry['Time']));
+  var actions = element('div', 'col-md-1');
+  var sup = element('sup');
+  sup.appendChild(
+    iconLink('fa-edit', function () {
+      return editClipboard(entry['ID']);
+    })
+  );
+  sup.appendChild(document.createTextNode(' '));
+  sup.appendChild(
+    iconLink('fa-trash', function () {
+      return delClipboard(entry['ID']);
+    })
+  );
+  actions.appendChild(sup);
+  var id = element('div', 'col-md-1');
+  id.appendChild(element('h5', '', entry['ID']));
+  header.appendChild(title);
+  header.appendChild(actions);
+  header.appendChild(id);
+
+  var body = element('div', 'card-body');
+  body.appendChild(element('pre', '', entry['Content']));
+
+  card.appendChild(header);
+  card.appendChild(body);
+  return card;
+}
+
 function sendEntry(e) {
   e.preventDefault();
   entryfield = document.getElementById('cbEntry');
   var text = entryfield.value;
+  if (!entryFits(text)) {
+    return;
+  }
   var msg = {
     type: 'newEntry',
     content: text,
@@ -108,8 +683,20 @@ function sendEntry(e) {
   entryfield.value = '';
 }
 
+// maxEntrySize has to match the limit of the server
+var maxEntrySize = 1 << 20;
+
+// entryFits will tell the user if an entry is too large for the clipboard
+function entryFits(text) {
+  if (new Blob([text]).size > maxEntrySize) {
+    alert('The entry is larger than ' + (maxEntrySize >> 10) + ' KiB');
+    return false;
+  }
+  return true;
+}
+
 function clearClipboard(e) {
-  e.preventDefault;
+  e.preventDefault();
   result = confirm('Are you sure you want to clear the clipboard?');
   if (result) {
     var msg = {
@@ -126,4 +713,21 @@ function delClipboard(id) {
     content: id,
   };
   connection.send(JSON.stringify(msg));
+  return false;
+}
+
+function editClipboard(id) {
+  var current = document.querySelector('#card-' + id + ' pre').innerText;
+  var text = prompt('Edit clipboard entry:', current);
+  if (text !== null && text !== current && entryFits(text)) {
+    var msg = {
+      type: 'editEntry',
+      content: {
+        id: id,
+        content: text,
+      },
+    };
+    connection.send(JSON.stringify(msg));
+  }
+  return false;
 }
@@ -3,12 +3,17 @@ module github.com/patrickhener/goshs
 go 1.15
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mdp/qrterminal v1.0.1
 	github.com/phogolabs/parcello v0.8.2
 	github.com/wellington/spritewell v0.5.0 // indirect
 	github.com/wellington/wellington v1.0.5 // indirect
-	golang.org/x/net v0.0.0-20201022231255-08b38378de70 // indirect
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/net v0.0.0-20201022231255-08b38378de70
 	golang.org/x/sys v0.0.0-20201022201747-fb209a7c41cd // indirect
+	gopkg.in/yaml.v2 v2.3.0
+	software.sslmate.com/src/go-pkcs12 v0.0.0-20200830195227-52f69702a001
 )
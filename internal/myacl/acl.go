@@ -0,0 +1,254 @@
+// Package myacl implements per path access control lists
+package myacl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permission is a single right that can be granted on a path
+type Permission string
+
+// The permissions known to goshs
+const (
+	Read      Permission = "read"
+	List      Permission = "list"
+	Upload    Permission = "upload"
+	Overwrite Permission = "overwrite"
+	Delete    Permission = "delete"
+)
+
+// Everyone matches every user including anonymous ones
+const Everyone = "*"
+
+// DirFile is the name of the per directory policy file
+const DirFile = ".goshs"
+
+// Rule grants permissions on a path prefix to users and groups
+type Rule struct {
+	Path   string       `json:"path"`
+	Users  []string     `json:"users"`
+	Groups []string     `json:"groups"`
+	Allow  []Permission `json:"allow"`
+}
+
+// Policy is the content of a policy file
+type Policy struct {
+	Groups map[string][]string `json:"groups"`
+	Rules  []Rule              `json:"rules"`
+}
+
+// ACL decides which user is allowed to do what on which path
+type ACL struct {
+	resolve  func(upath string) string
+	policy   *Policy
+	dirFiles bool
+
+	mu    sync.Mutex
+	cache map[string]cachedPolicy
+}
+
+type cachedPolicy struct {
+	modTime time.Time
+	rules   []Rule
+}
+
+// New will return an ACL using the policy file and, if dirFiles is set,
+// the .goshs files found in the directories. resolve maps url paths to directories on disk.
+// Without a policy file everyone is allowed everything unless a .goshs file says otherwise.
+func New(resolve func(upath string) string, policyFile string, dirFiles bool) (*ACL, error) {
+	a := &ACL{
+		resolve: resolve,
+		policy: &Policy{
+			Rules: []Rule{{
+				Path:  "/",
+				Users: []string{Everyone},
+				Allow: []Permission{Read, List, Upload, Overwrite, Delete},
+			}},
+		},
+		dirFiles: dirFiles,
+		cache:    make(map[string]cachedPolicy),
+	}
+
+	if policyFile != "" {
+		p, err := readPolicy(policyFile)
+		if err != nil {
+			return nil, err
+		}
+		for i := range p.Rules {
+			p.Rules[i].Path = cleanPath(p.Rules[i].Path)
+		}
+		a.policy = p
+	}
+
+	return a, nil
+}
+
+// Allowed will check if the user has the permission on the url path
+// Anonymous users are passed as empty string
+func (a *ACL) Allowed(user, upath string, perm Permission) bool {
+	return a.Permissions(user, upath)[perm]
+}
+
+// Permissions will return the effective permissions of the user on the url path.
+// The rules with the longest path prefix matching the user win, permissions of
+// rules with the same path are merged.
+func (a *ACL) Permissions(user, upath string) map[Permission]bool {
+	upath = cleanPath(upath)
+	perms := make(map[Permission]bool)
+	best := -1
+
+	for _, rule := range a.rules(upath) {
+		if !hasPrefix(upath, rule.Path) || !a.matches(user, rule) {
+			continue
+		}
+		if len(rule.Path) > best {
+			best = len(rule.Path)
+			perms = make(map[Permission]bool)
+		}
+		if len(rule.Path) == best {
+			for _, p := range rule.Allow {
+				perms[p] = true
+			}
+		}
+	}
+
+	return perms
+}
+
+// IsDirFile will check if the url path points to a per directory policy file
+func IsDirFile(upath string) bool {
+	return path.Base(upath) == DirFile
+}
+
+// rules will return all rules which might apply to the url path
+func (a *ACL) rules(upath string) []Rule {
+	if !a.dirFiles {
+		return a.policy.Rules
+	}
+	rules := append([]Rule(nil), a.policy.Rules...)
+
+	// Collect .goshs files from webroot down to the requested path
+	dir := "/"
+	parts := strings.Split(strings.Trim(upath, "/"), "/")
+	for i := 0; ; i++ {
+		rules = append(rules, a.dirRules(dir)...)
+		if i >= len(parts) || parts[i] == "" {
+			break
+		}
+		dir = path.Join(dir, parts[i])
+	}
+
+	return rules
+}
+
+// dirRules will return the rules of the .goshs file in dir with absolute paths
+func (a *ACL) dirRules(dir string) []Rule {
+	disk := a.resolve(dir)
+	if disk == "" {
+		return nil
+	}
+	file := filepath.Join(disk, DirFile)
+	stat, err := os.Stat(file)
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if cached, ok := a.cache[file]; ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.rules
+	}
+
+	p, err := readPolicy(file)
+	if err != nil {
+		log.Printf("ERROR: Ignoring invalid ACL file %+v: %+v", file, err)
+		p = &Policy{}
+	}
+	if len(p.Groups) > 0 {
+		log.Printf("WARNING: Groups can only be defined in the policy file, ignoring groups in %+v", file)
+	}
+	rules := make([]Rule, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		// A directory only governs itself and what is below it
+		rule.Path = cleanPath(path.Join(dir, rule.Path))
+		if !hasPrefix(rule.Path, cleanPath(dir)) {
+			log.Printf("WARNING: Ignoring rule for %+v outside of the directory in %+v", rule.Path, file)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	a.cache[file] = cachedPolicy{modTime: stat.ModTime(), rules: rules}
+
+	return rules
+}
+
+// matches will check if a rule applies to the user
+func (a *ACL) matches(user string, rule Rule) bool {
+	for _, u := range rule.Users {
+		if u == Everyone || (user != "" && u == user) {
+			return true
+		}
+	}
+	if user == "" {
+		return false
+	}
+	for _, g := range rule.Groups {
+		for _, member := range a.policy.Groups[g] {
+			if member == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readPolicy will read and validate a policy file
+func readPolicy(file string) (*Policy, error) {
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the policy file is given by the operator or lives in the webroot
+	// #nosec G304
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(content, p); err != nil {
+		return nil, fmt.Errorf("%s: %+v", file, err)
+	}
+
+	for _, rule := range p.Rules {
+		for _, perm := range rule.Allow {
+			switch perm {
+			case Read, List, Upload, Overwrite, Delete:
+			default:
+				return nil, fmt.Errorf("%s: unknown permission %q", file, perm)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// cleanPath will return a clean absolute url path
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// hasPrefix will check if prefix is a path prefix of p on component boundaries
+func hasPrefix(p, prefix string) bool {
+	if prefix == "/" || p == prefix {
+		return true
+	}
+	return strings.HasPrefix(p, prefix+"/")
+}
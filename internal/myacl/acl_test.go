@@ -0,0 +1,198 @@
+package myacl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile will write content to name below dir and return its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// webroot will resolve url paths below dir like a server without mounts
+func webroot(dir string) func(string) string {
+	return func(upath string) string {
+		return filepath.Join(dir, filepath.FromSlash(upath))
+	}
+}
+
+func TestAllowedPolicyFile(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, t.TempDir(), "policy.json", `{
+		"groups": {"team": ["alice", "bob"]},
+		"rules": [
+			{"path": "/", "users": ["*"], "allow": ["read", "list"]},
+			{"path": "/loot", "groups": ["team"], "allow": ["read", "list", "upload", "overwrite", "delete"]},
+			{"path": "/loot/private", "users": ["alice"], "allow": ["read"]},
+			{"path": "/loot/private", "users": ["alice"], "allow": ["list"]}
+		]
+	}`)
+
+	acl, err := New(webroot(dir), policy, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		path string
+		perm Permission
+		want bool
+	}{
+		{"", "/", Read, true},
+		{"", "/", Upload, false},
+		{"", "/loot/a.txt", Read, true},
+		{"", "/loot/a.txt", Delete, false},
+		{"alice", "/loot/a.txt", Delete, true},
+		{"bob", "/loot/sub/a.txt", Upload, true},
+		{"carol", "/loot/a.txt", Upload, false},
+		// The longest matching path wins, rules of the same path are merged
+		{"alice", "/loot/private/a.txt", Read, true},
+		{"alice", "/loot/private", List, true},
+		{"alice", "/loot/private/a.txt", Delete, false},
+		{"bob", "/loot/private/a.txt", Delete, true},
+		// Prefixes only match whole path components
+		{"alice", "/lootbox", Upload, false},
+		{"alice", "/loot/../etc", Upload, false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allowed(tt.user, tt.path, tt.perm); got != tt.want {
+			t.Errorf("Allowed(%q, %q, %s) = %v, want %v", tt.user, tt.path, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedWithoutPolicy(t *testing.T) {
+	acl, err := New(webroot(t.TempDir()), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range []Permission{Read, List, Upload, Overwrite, Delete} {
+		if !acl.Allowed("", "/a/b", perm) {
+			t.Errorf("Allowed without policy denied %s", perm)
+		}
+	}
+}
+
+func TestAllowedDirFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "pub/"+DirFile, `{"rules": [{"path": "/", "users": ["*"], "allow": ["read", "list"]}]}`)
+	writeFile(t, dir, "pub/upload/"+DirFile, `{"rules": [{"path": "in", "users": ["alice"], "allow": ["upload"]}]}`)
+
+	acl, err := New(webroot(dir), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		path string
+		perm Permission
+		want bool
+	}{
+		{"", "/other/a.txt", Delete, true},
+		{"", "/pub/a.txt", Read, true},
+		{"", "/pub/a.txt", Delete, false},
+		{"", "/pub/upload/in/a.txt", Upload, false},
+		{"alice", "/pub/upload/in/a.txt", Upload, true},
+		{"alice", "/pub/upload/in/a.txt", Read, false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allowed(tt.user, tt.path, tt.perm); got != tt.want {
+			t.Errorf("Allowed(%q, %q, %s) = %v, want %v", tt.user, tt.path, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestDirFileOutsideDirectory(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, t.TempDir(), "policy.json", `{"rules": [
+		{"path": "/", "users": ["*"], "allow": ["read", "list"]},
+		{"path": "/a", "users": ["*"], "allow": ["read", "list"]}
+	]}`)
+	writeFile(t, dir, "a/b/"+DirFile, `{"rules": [
+		{"path": "..", "users": ["*"], "allow": ["read", "list", "delete"]},
+		{"path": "sub/../../../a", "users": ["*"], "allow": ["upload"]}
+	]}`)
+
+	acl, err := New(webroot(dir), policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rules pointing above their directory must not add to the rules there
+	for _, perm := range []Permission{Delete, Upload} {
+		if acl.Allowed("", "/a/b/x.txt", perm) {
+			t.Errorf("/a/b/%s granted %s on /a", DirFile, perm)
+		}
+	}
+	if !acl.Allowed("", "/a/b/x.txt", Read) {
+		t.Error("read of the policy file lost")
+	}
+}
+
+func TestDirFileReload(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "pub/"+DirFile, `{"rules": [{"path": "/", "users": ["*"], "allow": ["read"]}]}`)
+
+	acl, err := New(webroot(dir), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl.Allowed("", "/pub/a.txt", Upload) {
+		t.Fatal("upload allowed before the change")
+	}
+
+	writeFile(t, dir, "pub/"+DirFile, `{"rules": [{"path": "/", "users": ["*"], "allow": ["read", "upload"]}]}`)
+	// The cache is keyed by modification time
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !acl.Allowed("", "/pub/a.txt", Upload) {
+		t.Error("upload denied after the change")
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"syntax":     `{"rules": [`,
+		"permission": `{"rules": [{"path": "/", "users": ["*"], "allow": ["execute"]}]}`,
+	}
+	for name, content := range tests {
+		file := writeFile(t, dir, name+".json", content)
+		if _, err := New(webroot(dir), file, false); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	if _, err := New(webroot(dir), filepath.Join(dir, "missing.json"), false); err == nil {
+		t.Error("missing policy file: no error")
+	}
+}
+
+func TestIsDirFile(t *testing.T) {
+	for upath, want := range map[string]bool{
+		"/" + DirFile:            true,
+		"/a/" + DirFile:          true,
+		"/a/" + DirFile + ".bak": false,
+		"/a.goshs":               false,
+	} {
+		if got := IsDirFile(upath); got != want {
+			t.Errorf("IsDirFile(%q) = %v, want %v", upath, got, want)
+		}
+	}
+}
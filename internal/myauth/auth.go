@@ -0,0 +1,230 @@
+// Package myauth handles the user database used for basic authentication
+package myauth
+
+import (
+	"bufio"
+	"crypto/subtle"
+
+	// disable G505 (CWE-327): Blocklisted import crypto/sha1: weak cryptographic primitive
+	// needed to verify {SHA} entries written by apache htpasswd
+	// #nosec G505
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultUser is the username used when only a password is given via -P
+const DefaultUser = "gopher"
+
+// dummyHash is compared against when an unknown user tries to log in
+// so that the response time does not reveal which users exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("goshs-dummy-password"), bcrypt.DefaultCost)
+
+// Users is the user database holding username and password hash
+type Users struct {
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+// entry is the password of a user, plain is set for passwords given
+// on the command line which are never taken as hash
+type entry struct {
+	hash  string
+	plain bool
+}
+
+// New will return an empty user database
+func New() *Users {
+	return &Users{
+		entries: make(map[string]entry),
+	}
+}
+
+// Add will add or replace a user with an already hashed (or plain) password entry.
+// Entries in a format goshs can not verify are rejected.
+func (u *Users) Add(username, hash string) error {
+	if err := checkUsername(username); err != nil {
+		return err
+	}
+	if !supported(hash) {
+		return fmt.Errorf("unsupported hash format for user %s", username)
+	}
+	u.set(username, entry{hash: hash})
+	return nil
+}
+
+// AddPassword will add or replace a user with a plain text password,
+// which is compared as is even if it looks like a hash
+func (u *Users) AddPassword(username, password string) error {
+	if err := checkUsername(username); err != nil {
+		return err
+	}
+	u.set(username, entry{hash: password, plain: true})
+	return nil
+}
+
+func (u *Users) set(username string, e entry) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.entries[username] = e
+}
+
+// checkUsername will make sure the username fits into a htpasswd line
+func checkUsername(username string) error {
+	if username == "" {
+		return errors.New("username must not be empty")
+	}
+	if strings.Contains(username, ":") {
+		return fmt.Errorf("username %q must not contain ':'", username)
+	}
+	return nil
+}
+
+// Len will return the amount of users in the database
+func (u *Users) Len() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return len(u.entries)
+}
+
+// Names will return the sorted usernames in the database
+func (u *Users) Names() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	names := make([]string, 0, len(u.entries))
+	for name := range u.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify will check username and password against the database
+func (u *Users) Verify(username, password string) bool {
+	u.mu.RLock()
+	e, ok := u.entries[username]
+	u.mu.RUnlock()
+
+	if !ok {
+		// Burn the same time as a real check would
+		// disable G104 (CWE-703): Errors unhandled
+		// #nosec G104
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	if e.plain {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(e.hash)) == 1
+	}
+	return compare(e.hash, password)
+}
+
+// LoadHtpasswd will read an apache style htpasswd file into a user database
+func LoadHtpasswd(path string) (*Users, error) {
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the path is given by the operator
+	// #nosec G304
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// disable G307 (CWE-703): Deferring unsafe method "Close" on type "*os.File"
+	// #nosec G307
+	defer file.Close()
+
+	users := New()
+	if err := users.ReadHtpasswd(file); err != nil {
+		return nil, fmt.Errorf("%s: %+v", path, err)
+	}
+
+	return users, nil
+}
+
+// ReadHtpasswd will parse htpasswd formatted lines into the user database
+func (u *Users) ReadHtpasswd(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("line %d: malformed entry, expected user:hash", lineNo)
+		}
+		if err := u.Add(parts[0], parts[1]); err != nil {
+			return fmt.Errorf("line %d: %+v", lineNo, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// HashPassword will return a bcrypt hash suitable for a htpasswd file
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// supported will check if the hash format can be verified by goshs.
+// Entries without a $id$ or {scheme} prefix are plain text passwords,
+// any other scheme is rejected so that a hash never becomes the password.
+func supported(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$5$", "$6$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return !hasScheme(hash)
+}
+
+// hasScheme will check if the entry starts with a $id$ or {scheme} prefix
+func hasScheme(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$"):
+		return strings.Contains(hash[1:], "$")
+	case strings.HasPrefix(hash, "{"):
+		return strings.Contains(hash, "}")
+	}
+	return false
+}
+
+// compare will check a password against a htpasswd hash in constant time
+func compare(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$5$"), strings.HasPrefix(hash, "$6$"):
+		computed, err := shaCrypt(password, hash)
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		// disable G401 (CWE-326): Use of weak cryptographic primitive
+		// #nosec G401
+		sum := sha1.Sum([]byte(password))
+		computed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	case hasScheme(hash):
+		// Never compare a hash goshs can not check as password
+		return false
+	default:
+		// plain text entry
+		return subtle.ConstantTimeCompare([]byte(password), []byte(hash)) == 1
+	}
+}
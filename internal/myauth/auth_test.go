@@ -0,0 +1,160 @@
+package myauth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt
+var shaCryptTests = []struct {
+	setting string
+	want    string
+}{
+	{"$5$saltstring", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	{"$5$rounds=10000$saltstringsaltstring", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{"$6$saltstring", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"$6$rounds=10000$saltstringsaltstring", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+}
+
+func TestShaCrypt(t *testing.T) {
+	for _, tt := range shaCryptTests {
+		got, err := shaCrypt("Hello world!", tt.setting)
+		if err != nil {
+			t.Errorf("shaCrypt(%q): %+v", tt.setting, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("shaCrypt(%q) = %q, want %q", tt.setting, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	bcryptHash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := New()
+	entries := map[string]string{
+		"bcrypt": bcryptHash,
+		"sha256": shaCryptTests[0].want,
+		"sha512": shaCryptTests[2].want,
+		"sha1":   "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"plain":  "plaintext",
+	}
+	for name, hash := range entries {
+		if err := users.Add(name, hash); err != nil {
+			t.Fatalf("Add(%s): %+v", name, err)
+		}
+	}
+
+	// Hashes goshs can not check must not become the password
+	unsupported := map[string]string{
+		"ssha":   "{SSHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"argon2": "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA",
+	}
+	for name, hash := range unsupported {
+		if err := users.Add(name, hash); err == nil {
+			t.Errorf("Add(%s, %q): no error", name, hash)
+		}
+		if users.Verify(name, hash) {
+			t.Errorf("%s logs in with the hash as password", name)
+		}
+	}
+
+	tests := []struct {
+		user     string
+		password string
+		want     bool
+	}{
+		{"bcrypt", "secret", true},
+		{"bcrypt", "wrong", false},
+		{"sha256", "Hello world!", true},
+		{"sha256", "Hello world", false},
+		{"sha512", "Hello world!", true},
+		{"sha1", "password", true},
+		{"sha1", "Password", false},
+		{"plain", "plaintext", true},
+		{"plain", "plain", false},
+		{"unknown", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := users.Verify(tt.user, tt.password); got != tt.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestAddPassword(t *testing.T) {
+	users := New()
+	// A password looking like a hash is still compared as is
+	for _, password := range []string{"$2a$10$abc", "$6$salt$abc", "{SHA}abc", "$apr1$abc"} {
+		if err := users.AddPassword(DefaultUser, password); err != nil {
+			t.Fatalf("AddPassword(%q): %+v", password, err)
+		}
+		if !users.Verify(DefaultUser, password) {
+			t.Errorf("Verify(%q) failed", password)
+		}
+	}
+}
+
+func TestAddRejects(t *testing.T) {
+	users := New()
+	tests := map[string]string{
+		"":      "plain",
+		"a:b":   "plain",
+		"md5":   "$apr1$salt$hash",
+		"crypt": "$1$salt$hash",
+		"2x":    "$2x$10$abcdefghijklmnopqrstuv",
+		"typo":  "$6saltstring$abc",
+	}
+	for name, hash := range tests {
+		if err := users.Add(name, hash); err == nil {
+			t.Errorf("Add(%q, %q): no error", name, hash)
+		}
+	}
+	if users.Len() != 0 {
+		t.Errorf("Len() = %d after rejected entries", users.Len())
+	}
+}
+
+func TestReadHtpasswd(t *testing.T) {
+	users := New()
+	content := "# comment\n\nalice:plain\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+	if err := users.ReadHtpasswd(strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(users.Names(), ","); got != "alice,bob" {
+		t.Errorf("Names() = %s", got)
+	}
+	if !users.Verify("bob", "password") {
+		t.Error("bob can not log in")
+	}
+
+	for _, content := range []string{"alice\n", ":hash\n", "alice:$apr1$salt$hash\n"} {
+		if err := New().ReadHtpasswd(strings.NewReader(content)); err == nil {
+			t.Errorf("ReadHtpasswd(%q): no error", content)
+		}
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := ioutil.WriteFile(file, []byte("alice:plain\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !users.Verify("alice", "plain") {
+		t.Error("alice can not log in")
+	}
+
+	if _, err := LoadHtpasswd(file + ".missing"); err == nil {
+		t.Error("missing file: no error")
+	}
+}
@@ -0,0 +1,196 @@
+package myauth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt as specified by Ulrich Drepper
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptSaltMax       = 16
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+)
+
+// Byte permutation used when encoding the final digest
+var (
+	sha256Order = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512Order = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt will hash the password with the settings ($5$ or $6$, rounds and salt)
+// taken from an existing hash and return the complete hash string
+func shaCrypt(password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var prefix string
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		newHash, prefix = sha256.New, "$5$"
+	case strings.HasPrefix(setting, "$6$"):
+		newHash, prefix = sha512.New, "$6$"
+	default:
+		return "", errors.New("not a SHA-crypt hash")
+	}
+
+	rest := setting[len(prefix):]
+	rounds := shaCryptRoundsDefault
+	customRounds := false
+	if strings.HasPrefix(rest, "rounds=") {
+		end := strings.Index(rest, "$")
+		if end < 0 {
+			return "", errors.New("malformed SHA-crypt rounds")
+		}
+		r, err := strconv.Atoi(rest[len("rounds="):end])
+		if err != nil {
+			return "", errors.New("malformed SHA-crypt rounds")
+		}
+		if r < shaCryptRoundsMin {
+			r = shaCryptRoundsMin
+		}
+		if r > shaCryptRoundsMax {
+			r = shaCryptRoundsMax
+		}
+		rounds = r
+		customRounds = true
+		rest = rest[end+1:]
+	}
+
+	salt := rest
+	if end := strings.Index(salt, "$"); end >= 0 {
+		salt = salt[:end]
+	}
+	if len(salt) > shaCryptSaltMax {
+		salt = salt[:shaCryptSaltMax]
+	}
+
+	p := []byte(password)
+	s := []byte(salt)
+
+	// Digest B
+	h := newHash()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+	size := len(b)
+
+	// Digest A
+	h = newHash()
+	h.Write(p)
+	h.Write(s)
+	for n := len(p); n > 0; n -= size {
+		if n > size {
+			h.Write(b)
+		} else {
+			h.Write(b[:n])
+		}
+	}
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	// Byte sequence P
+	h = newHash()
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+	dp := h.Sum(nil)
+	pSeq := repeatTo(dp, len(p))
+
+	// Byte sequence S
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	sSeq := repeatTo(ds, len(s))
+
+	// Rounds
+	c := a
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sSeq)
+		}
+		if i%7 != 0 {
+			h.Write(pSeq)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pSeq)
+		}
+		c = h.Sum(nil)
+	}
+
+	// Encode result
+	out := strings.Builder{}
+	out.WriteString(prefix)
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	if size == sha256.Size {
+		for _, o := range sha256Order {
+			encode24(&out, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		encode24(&out, 0, c[31], c[30], 3)
+	} else {
+		for _, o := range sha512Order {
+			encode24(&out, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		encode24(&out, 0, 0, c[63], 2)
+	}
+
+	return out.String(), nil
+}
+
+// repeatTo will repeat the digest until it is n bytes long
+func repeatTo(digest []byte, n int) []byte {
+	seq := make([]byte, 0, n)
+	for len(seq) < n {
+		remain := n - len(seq)
+		if remain > len(digest) {
+			remain = len(digest)
+		}
+		seq = append(seq, digest[:remain]...)
+	}
+	return seq
+}
+
+// encode24 will write n characters of the crypt base64 representation of three bytes
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
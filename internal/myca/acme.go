@@ -0,0 +1,110 @@
+package myca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig holds the settings to get certificates from an ACME server
+// like Let's Encrypt or an internal one like step-ca or pebble
+type ACMEConfig struct {
+	Domains      []string
+	DirectoryURL string
+	Email        string
+	CacheDir     string
+	// RootCA is a pem file with the root of an internal ACME server
+	RootCA string
+}
+
+// ACME will return the certificate manager for the ACME server. Its TLSConfig answers
+// TLS-ALPN-01 challenges, its HTTPHandler HTTP-01 challenges. Certificates are requested
+// on the first connection, stored in the cache dir and renewed automatically.
+func ACME(c ACMEConfig) (*autocert.Manager, error) {
+	if len(c.Domains) == 0 {
+		return nil, errors.New("at least one domain is needed for ACME")
+	}
+
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if c.CacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("no ACME cache dir given and no user cache dir: %+v", err)
+		}
+		c.CacheDir = filepath.Join(dir, "goshs", "acme")
+	}
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+	if c.RootCA != "" {
+		httpClient, err := rootCAClient(c.RootCA)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = httpClient
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Client:     client,
+		Email:      c.Email,
+	}, nil
+}
+
+// rootCAClient will return a http client trusting the system roots and the roots in file
+func rootCAClient(file string) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if err := appendPEMFile(pool, file); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// CertPool will return a pool with the certificates of the pem bundle file
+func CertPool(file string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if err := appendPEMFile(pool, file); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// appendPEMFile will add the certificates of the pem bundle file to pool
+func appendPEMFile(pool *x509.CertPool, file string) error {
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the file is given by the user starting goshs
+	// #nosec G304
+	pemBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return fmt.Errorf("no certificates found in %s", file)
+	}
+	return nil
+}
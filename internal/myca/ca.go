@@ -4,7 +4,10 @@
 package myca
 
 import (
-	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 
@@ -15,15 +18,20 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/patrickhener/goshs/internal/myutils"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // Sum will give the sha256 and sha1 sum of the certificate
@@ -90,123 +98,423 @@ func ParseAndSum(cert string) (sha256s, sha1s string, err error) {
 	return sha256s, sha1s, nil
 }
 
-// Setup will deliver a fully initialized CA and server cert
-func Setup() (serverTLSConf *tls.Config, sha256s, sha1s string, err error) {
-	randInt, err := myutils.RandomNumber()
+// CA is the certificate authority signing the self-signed server certificates
+type CA struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	options Options
+}
+
+// Options are the settings for the self-signed certificates
+type Options struct {
+	// Names are additional DNS names or IP addresses of the server certificate
+	Names []string
+	// KeyType is rsa (4096 bit), ecdsa (P-256) or ed25519
+	KeyType string
+	// Validity of the server certificate, the CA is valid for 10 years
+	Validity time.Duration
+	// Subject like "CN=goshs,O=example,C=DE", the CA gets " CA" appended to its CN
+	Subject string
+}
+
+const (
+	// caCertFile is the name of the CA certificate in the CA directory
+	caCertFile = "ca.crt"
+	// caKeyFile is the name of the CA key in the CA directory
+	caKeyFile = "ca.key"
+	// defaultValidity is the validity of a server certificate if not configured
+	defaultValidity = 365 * 24 * time.Hour
+)
+
+// defaultSubject is used if no subject is configured
+var defaultSubject = pkix.Name{
+	Organization:       []string{"hesec.de"},
+	OrganizationalUnit: []string{"hesec.de"},
+	CommonName:         "goshs - SimpleHTTPServer",
+	Country:            []string{"DE"},
+	Province:           []string{"BW"},
+	Locality:           []string{"Althengstett"},
+	StreetAddress:      []string{"Gopher-Street"},
+	PostalCode:         []string{"75382"},
+}
+
+// NewCA will create a new CA
+func NewCA(opts Options) (*CA, error) {
+	subject, err := ParseSubject(opts.Subject)
+	if err != nil {
+		return nil, err
+	}
+	subject.CommonName += " CA"
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	// create our private and public key
+	caPrivKey, err := generateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	ski, err := subjectKeyID(caPrivKey.Public())
 	if err != nil {
-		log.Printf("Error when creating certificate: %+v", err)
+		return nil, err
 	}
+
 	ca := &x509.Certificate{
-		SerialNumber: &randInt,
-		Subject: pkix.Name{
-			Organization:       []string{"hesec.de"},
-			OrganizationalUnit: []string{"hesec.de"},
-			CommonName:         "goshs - SimpleHTTPServer",
-			Country:            []string{"DE"},
-			Province:           []string{"BW"},
-			Locality:           []string{"Althengstett"},
-			StreetAddress:      []string{"Gopher-Street"},
-			PostalCode:         []string{"75382"},
-		},
+		SerialNumber:          serial,
+		Subject:               subject,
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(10, 0, 0),
 		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		SubjectKeyId:          ski,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 
-	// create our private and public key
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	// create the CA
+	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, caPrivKey.Public(), caPrivKey)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	// create the CA
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivKey.PublicKey, caPrivKey)
+	return &CA{Cert: caCert, Key: caPrivKey, options: opts}, nil
+}
+
+// LoadCA will load the CA from dir. If there is none yet a new one
+// is created and saved to dir so that it is reused on the next start.
+func LoadCA(dir string, opts Options) (*CA, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the directory is given by the user starting goshs
+	// #nosec G304
+	certPEM, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		ca, err := NewCA(opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := ca.Save(dir); err != nil {
+			return nil, err
+		}
+		log.Printf("INFO:  Created new CA in %+v\n", dir)
+		return ca, nil
+	}
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
 
-	// pem encode
-	caPEM := new(bytes.Buffer)
-	if err := pem.Encode(caPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: caBytes,
-	}); err != nil {
-		log.Printf("ERROR: Error encoding pem: %+v", err)
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the directory is given by the user starting goshs
+	// #nosec G304
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
 	}
 
-	caPrivKeyPEM := new(bytes.Buffer)
-	if err := pem.Encode(caPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(caPrivKey),
-	}); err != nil {
-		log.Printf("ERROR: Error encoding pem: %+v", err)
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no certificate found in %s", certPath)
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certPath)
 	}
 
-	randInt, err = myutils.RandomNumber()
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no private key found in %s", keyPath)
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
 	if err != nil {
-		log.Printf("Error when creating certificate: %+v", err)
+		return nil, fmt.Errorf("unable to read %s: %+v", keyPath, err)
 	}
-	// set up our server certificate
-	cert := &x509.Certificate{
-		SerialNumber: &randInt,
-		Subject: pkix.Name{
-			Organization:       []string{"hesec.de"},
-			OrganizationalUnit: []string{"hesec.de"},
-			CommonName:         "goshs - SimpleHTTPServer",
-			Country:            []string{"DE"},
-			Province:           []string{"BW"},
-			Locality:           []string{"Althengstett"},
-			StreetAddress:      []string{"Gopher-Street"},
-			PostalCode:         []string{"75382"},
-		},
-		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(10, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-	}
-
-	certPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+
+	return &CA{Cert: caCert, Key: key, options: opts}, nil
+}
+
+// Save will write the CA certificate and key to dir
+func (ca *CA) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(ca.Key)
 	if err != nil {
-		return nil, "", "", err
+		return err
 	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	if err := ioutil.WriteFile(filepath.Join(dir, caKeyFile), keyPEM, 0600); err != nil {
+		return err
+	}
+
+	// disable G306 (CWE-276): Expect WriteFile permissions to be 0600 or less
+	// as the certificate is public
+	// #nosec G306
+	return ioutil.WriteFile(filepath.Join(dir, caCertFile), ca.PEM(), 0644)
+}
+
+// PEM will return the pem encoded CA certificate
+func (ca *CA) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, &certPrivKey.PublicKey, caPrivKey)
+// ServerConfig will mint a fresh server certificate signed by the CA
+func (ca *CA) ServerConfig() (serverTLSConf *tls.Config, sha256s, sha1s string, err error) {
+	subject, err := ParseSubject(ca.options.Subject)
+	if err != nil {
+		return nil, "", "", err
+	}
+	serial, err := serialNumber()
 	if err != nil {
 		return nil, "", "", err
 	}
+	validity := ca.options.Validity
+	if validity <= 0 {
+		validity = defaultValidity
+	}
 
-	certPEM := new(bytes.Buffer)
-	if err := pem.Encode(certPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certBytes,
-	}); err != nil {
-		log.Printf("ERROR: Error encoding pem: %+v", err)
+	certPrivKey, err := generateKey(ca.options.KeyType)
+	if err != nil {
+		return nil, "", "", err
+	}
+	ski, err := subjectKeyID(certPrivKey.Public())
+	if err != nil {
+		return nil, "", "", err
 	}
 
-	certPrivKeyPEM := new(bytes.Buffer)
-	if err := pem.Encode(certPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(certPrivKey),
-	}); err != nil {
-		log.Printf("ERROR: Error encoding pem: %+v", err)
+	// set up our server certificate
+	cert := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        subject,
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(validity),
+		SubjectKeyId:   ski,
+		AuthorityKeyId: ca.Cert.SubjectKeyId,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+	// RSA keys are used for key exchange with older TLS clients
+	if _, ok := certPrivKey.(*rsa.PrivateKey); ok {
+		cert.KeyUsage |= x509.KeyUsageKeyEncipherment
 	}
+	cert.DNSNames, cert.IPAddresses = ca.options.subjectAltNames()
 
-	serverCert, err := tls.X509KeyPair(certPEM.Bytes(), certPrivKeyPEM.Bytes())
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, ca.Cert, certPrivKey.Public(), ca.Key)
 	if err != nil {
 		return nil, "", "", err
 	}
 
 	serverTLSConf = &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certBytes},
+			PrivateKey:  certPrivKey,
+		}},
+		MinVersion: tls.VersionTLS12,
 	}
 
 	sha256s, sha1s = Sum(certBytes)
 
 	return
 }
+
+// ClientCert will issue a certificate for user to authenticate against goshs.
+// It is returned as PKCS#12 bundle including the CA certificate and protected by password.
+func (ca *CA) ClientCert(user string, emails []string, password string) (p12 []byte, cert *x509.Certificate, err error) {
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	validity := ca.options.Validity
+	if validity <= 0 {
+		validity = defaultValidity
+	}
+
+	// PKCS#12 bundles with Ed25519 keys can not be imported by browsers
+	keyType := ca.options.KeyType
+	if strings.ToLower(keyType) == "ed25519" {
+		keyType = "ecdsa"
+	}
+	certPrivKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	ski, err := subjectKeyID(certPrivKey.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: user, Organization: ca.Cert.Subject.Organization},
+		EmailAddresses: emails,
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(validity),
+		SubjectKeyId:   ski,
+		AuthorityKeyId: ca.Cert.SubjectKeyId,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, certPrivKey.Public(), ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err = x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p12, err = pkcs12.Encode(rand.Reader, certPrivKey, cert, []*x509.Certificate{ca.Cert}, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p12, cert, nil
+}
+
+// ParseSubject will read a subject like "CN=goshs,O=example,OU=it,C=DE,ST=BW,L=Town".
+// An empty subject gives the default one.
+func ParseSubject(s string) (pkix.Name, error) {
+	if strings.TrimSpace(s) == "" {
+		return defaultSubject, nil
+	}
+
+	var name pkix.Name
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return pkix.Name{}, fmt.Errorf("invalid subject part %q, use KEY=value", part)
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToUpper(strings.TrimSpace(kv[0])) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		default:
+			return pkix.Name{}, fmt.Errorf("unknown subject field %q", kv[0])
+		}
+	}
+	if name.CommonName == "" {
+		return pkix.Name{}, errors.New("the subject needs a CN")
+	}
+
+	return name, nil
+}
+
+// subjectAltNames will collect localhost, the hostname, the addresses of all
+// interfaces and the configured names for the server certificate
+func (o Options) subjectAltNames() (dnsNames []string, ips []net.IP) {
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		if ip := net.ParseIP(name); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+
+	add("localhost")
+	add("127.0.0.1")
+	add("::1")
+	if hostname, err := os.Hostname(); err == nil {
+		add(hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				add(ipnet.IP.String())
+			}
+		}
+	}
+	for _, name := range o.Names {
+		add(name)
+	}
+
+	return dnsNames, ips
+}
+
+// generateKey will create a private key of type rsa, ecdsa or ed25519
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case "", "rsa":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unknown key type %s, use rsa, ecdsa or ed25519", keyType)
+}
+
+// subjectKeyID will return the sha1 sum of the public key as described in RFC 5280
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	// disable "G401 (CWE-326): Use of weak cryptographic primitive"
+	// as this is only an identifier
+	// #nosec G401
+	sum := sha1.Sum(info.SubjectPublicKey.Bytes)
+	return sum[:], nil
+}
+
+// serialNumber will return a random 128 bit serial number.
+// Serials must not repeat for certificates of the same CA.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// parsePrivateKey will read a PKCS#8, PKCS#1 or EC private key
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
@@ -0,0 +1,147 @@
+package myca
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay gives tools replacing certificate and key one after another time to finish
+const reloadDelay = 500 * time.Millisecond
+
+// Reloader holds the user provided certificate and reloads it whenever
+// the certificate or key file changes or goshs receives SIGHUP
+type Reloader struct {
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certFile string
+	keyFile  string
+	watcher  *fsnotify.Watcher
+	signals  chan os.Signal
+	done     chan struct{}
+}
+
+// NewReloader will load the key pair and start watching it
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		signals:  make(chan os.Signal, 1),
+		done:     make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directories as rotating usually replaces the files
+	dirs := map[string]bool{filepath.Dir(certFile): true, filepath.Dir(keyFile): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	r.watcher = watcher
+
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go r.run()
+
+	return r, nil
+}
+
+// Reload will load the key pair from disk. The current certificate
+// is kept if the new pair is invalid.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate will hand the current certificate to tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// Close will stop watching
+func (r *Reloader) Close() error {
+	signal.Stop(r.signals)
+	close(r.done)
+	return r.watcher.Close()
+}
+
+// run will reload the key pair on changes until the reloader is closed
+func (r *Reloader) run() {
+	// timer collects the events of one rotation into a single reload
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Chmod == event.Op || !r.watched(event.Name) {
+				continue
+			}
+			timer.Reset(reloadDelay)
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("ERROR: Error watching certificate: %+v", err)
+		case <-r.signals:
+			log.Println("INFO:  Received SIGHUP, reloading certificate")
+			r.reload()
+		case <-timer.C:
+			r.reload()
+		case <-r.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// reload will reload the key pair and log the outcome
+func (r *Reloader) reload() {
+	if err := r.Reload(); err != nil {
+		log.Printf("ERROR: Unable to reload certificate, keeping the current one: %+v", err)
+		return
+	}
+
+	sha256s, sha1s, err := ParseAndSum(r.certFile)
+	if err != nil {
+		log.Printf("ERROR: Unable to read the fingerprint of the reloaded certificate: %+v", err)
+		return
+	}
+	log.Printf("INFO:  Reloaded certificate %+v", r.certFile)
+	log.Printf("SHA-256 Fingerprint: %+v\n", sha256s)
+	log.Printf("SHA-1   Fingerprint: %+v\n", sha1s)
+}
+
+// watched will check if name is the certificate or the key file
+func (r *Reloader) watched(name string) bool {
+	name = filepath.Clean(name)
+	return name == filepath.Clean(r.certFile) || name == filepath.Clean(r.keyFile)
+}
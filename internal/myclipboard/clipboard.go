@@ -2,16 +2,35 @@ package myclipboard
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
-// Clipboard is the in memory clipboard to hold the copy-pasteable content
+// Clipboard is the clipboard to hold the copy-pasteable content.
+// It is safe for concurrent use and optionally persisted to a json file.
 type Clipboard struct {
-	Entries []Entry
+	mu      sync.RWMutex
+	entries []Entry
+	next    int
+	file    string
 }
 
-// Entry will represent a single entry in the clipboard
+// MaxEntrySize is the maximum size of the content of an entry in bytes
+const MaxEntrySize = 1 << 20
+
+// ErrNotFound is returned when there is no entry with the requested id
+var ErrNotFound = errors.New("clipboard entry not found")
+
+// ErrTooLarge is returned when the content of an entry exceeds MaxEntrySize
+var ErrTooLarge = fmt.Errorf("clipboard entry is larger than %d KiB", MaxEntrySize>>10)
+
+// Entry will represent a single entry in the clipboard.
+// The ID is unique and never reused for the lifetime of the clipboard.
 type Entry struct {
 	ID      int
 	Content string
@@ -24,54 +43,246 @@ func New() *Clipboard {
 	return cb
 }
 
-// AddEntry will give the opportunity to add an entry to the clipboard
-func (c *Clipboard) AddEntry(con string) error {
-	entries := c.Entries
-	if len(entries) > 0 {
-		lastEntry := entries[len(entries)-1]
-		newID := lastEntry.ID + 1
-		entries = append(entries, Entry{
-			ID:      newID,
-			Content: con,
-			Time:    fmt.Sprintf("%s", time.Now().Format("Mon Jan _2 15:04:05 2006")),
-		})
-	} else {
-		entries = append(entries, Entry{
-			ID:      0,
-			Content: con,
-			Time:    fmt.Sprintf("%s", time.Now().Format("Mon Jan _2 15:04:05 2006")),
-		})
-	}
-	c.Entries = entries
-	return nil
+// Load will return a Clipboard which is persisted to file.
+// Entries already stored in file are loaded, a missing file is created on the first change.
+func Load(file string) (*Clipboard, error) {
+	cb := &Clipboard{file: file}
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the file is given by the user starting goshs
+	// #nosec G304
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cb, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return cb, nil
+	}
+	if err := json.Unmarshal(content, &cb.entries); err != nil {
+		return nil, fmt.Errorf("%s is not a valid clipboard file: %+v", file, err)
+	}
+	for _, e := range cb.entries {
+		if e.ID >= cb.next {
+			cb.next = e.ID + 1
+		}
+	}
+
+	return cb, nil
+}
+
+// AddEntry will give the opportunity to add an entry to the clipboard.
+// It returns the new entry.
+func (c *Clipboard) AddEntry(con string) (Entry, error) {
+	if len(con) > MaxEntrySize {
+		return Entry{}, ErrTooLarge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.snapshot()
+	e := Entry{
+		ID:      c.nextID(),
+		Content: con,
+		Time:    time.Now().Format("Mon Jan _2 15:04:05 2006"),
+	}
+	c.entries = append(c.entries, e)
+	if err := c.commit(old); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
 }
 
 // DeleteEntry will give the opportunity to delete an entry from the clipboard
 func (c *Clipboard) DeleteEntry(id int) error {
-	entries := c.Entries
-	entries = append(entries[:id], entries[id+1:]...)
-	c.Entries = entries
-	return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	old := c.snapshot()
+	c.entries = append(c.entries[:i], c.entries[i+1:]...)
+	return c.commit(old)
+}
+
+// EditEntry will replace the content of an entry in place.
+// It returns the updated entry.
+func (c *Clipboard) EditEntry(id int, con string) (Entry, error) {
+	if len(con) > MaxEntrySize {
+		return Entry{}, ErrTooLarge
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.find(id)
+	if i < 0 {
+		return Entry{}, ErrNotFound
+	}
+	old := c.snapshot()
+	c.entries[i].Content = con
+	c.entries[i].Time = time.Now().Format("Mon Jan _2 15:04:05 2006")
+	if err := c.commit(old); err != nil {
+		return Entry{}, err
+	}
+	return c.entries[i], nil
+}
+
+// GetEntry will return the entry with the given id
+func (c *Clipboard) GetEntry(id int) (Entry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i := c.find(id)
+	if i < 0 {
+		return Entry{}, ErrNotFound
+	}
+	return c.entries[i], nil
 }
 
 // ClearClipboard will empty the clipboard
 func (c *Clipboard) ClearClipboard() error {
-	c.Entries = nil
-	return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.snapshot()
+	c.entries = nil
+	return c.commit(old)
 }
 
 // GetEntries will give the opportunity to receive the entries from the clipboard
 func (c *Clipboard) GetEntries() ([]Entry, error) {
-	entries := c.Entries
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := append([]Entry(nil), c.entries...)
 	return entries, nil
 }
 
 // Download will return a json encoded representation of the clipboards content for download purposes
 func (c *Clipboard) Download() ([]byte, error) {
-	entries := c.Entries
-	e, err := json.MarshalIndent(entries, "", "    ")
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, err := json.MarshalIndent(c.entries, "", "    ")
 	if err != nil {
 		return nil, err
 	}
 	return e, nil
 }
+
+// Import will add the entries of a json file created by Download to the clipboard.
+// The entries keep their content and time but get new ids.
+func (c *Clipboard) Import(content []byte) (int, error) {
+	var imported []Entry
+	if err := json.Unmarshal(content, &imported); err != nil {
+		return 0, fmt.Errorf("not a clipboard export: %+v", err)
+	}
+	for _, e := range imported {
+		if e.Time == "" {
+			return 0, errors.New("not a clipboard export: entry without time")
+		}
+		if len(e.Content) > MaxEntrySize {
+			return 0, ErrTooLarge
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.snapshot()
+	for _, e := range imported {
+		e.ID = c.nextID()
+		c.entries = append(c.entries, e)
+	}
+	if err := c.commit(old); err != nil {
+		return 0, err
+	}
+	return len(imported), nil
+}
+
+// nextID will hand out a new unique id, the caller has to hold the lock
+func (c *Clipboard) nextID() int {
+	id := c.next
+	c.next++
+	return id
+}
+
+// find will return the index of the entry with id or -1, the caller has to hold the lock
+func (c *Clipboard) find(id int) int {
+	for i, e := range c.entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshot will copy the entries to restore them if saving a change fails,
+// the caller has to hold the lock
+func (c *Clipboard) snapshot() []Entry {
+	return append([]Entry(nil), c.entries...)
+}
+
+// commit will save a change or restore the entries of the snapshot old if that fails,
+// so that memory never differs from what the clients were told. The caller has to hold the lock.
+func (c *Clipboard) commit(old []Entry) error {
+	if err := c.save(); err != nil {
+		c.entries = old
+		return err
+	}
+	return nil
+}
+
+// Save will write the entries to the clipboard file if there is one
+func (c *Clipboard) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+// save will write the entries to the clipboard file if there is one, the caller has to hold the lock.
+// The file is replaced atomically so that a crash never leaves a truncated file behind.
+func (c *Clipboard) save() error {
+	if c.file == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(c.entries, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), filepath.Base(c.file)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to save clipboard: %+v", err)
+	}
+	defer func() {
+		// Only left over if something went wrong
+		// disable G104 (CWE-703): Errors unhandled
+		// #nosec G104
+		os.Remove(tmp.Name())
+	}()
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save clipboard: %+v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return fmt.Errorf("unable to save clipboard: %+v", err)
+	}
+
+	return nil
+}
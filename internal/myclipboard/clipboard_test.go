@@ -0,0 +1,87 @@
+package myclipboard
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "clipboard.json")
+	cb, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := cb.AddEntry("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cb.AddEntry("second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cb.EditEntry(first.ID, "edited"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := loaded.GetEntries()
+	if len(entries) != 2 || entries[0].Content != "edited" || entries[1].Content != "second" {
+		t.Fatalf("loaded entries %+v", entries)
+	}
+
+	// Ids are never reused after a restart
+	e, err := loaded.AddEntry("third")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID <= entries[1].ID {
+		t.Errorf("id %d reused", e.ID)
+	}
+}
+
+func TestFailedSaveKeepsEntries(t *testing.T) {
+	// The directory of the file does not exist, so every save fails
+	cb, err := Load(filepath.Join(t.TempDir(), "missing", "clipboard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.entries = []Entry{{ID: 0, Content: "kept", Time: "Mon Jan  2 15:04:05 2006"}}
+	cb.next = 1
+
+	if _, err := cb.AddEntry("new"); err == nil {
+		t.Error("AddEntry: no error")
+	}
+	if _, err := cb.EditEntry(0, "changed"); err == nil {
+		t.Error("EditEntry: no error")
+	}
+	if err := cb.DeleteEntry(0); err == nil {
+		t.Error("DeleteEntry: no error")
+	}
+	if err := cb.ClearClipboard(); err == nil {
+		t.Error("ClearClipboard: no error")
+	}
+	if _, err := cb.Import([]byte(`[{"Content": "imported", "Time": "Mon Jan  2 15:04:05 2006"}]`)); err == nil {
+		t.Error("Import: no error")
+	}
+
+	entries, _ := cb.GetEntries()
+	if len(entries) != 1 || entries[0].Content != "kept" {
+		t.Errorf("entries changed by failed saves: %+v", entries)
+	}
+}
+
+func TestEntrySize(t *testing.T) {
+	cb := New()
+	if _, err := cb.AddEntry(strings.Repeat("a", MaxEntrySize)); err != nil {
+		t.Fatalf("entry of the maximum size: %+v", err)
+	}
+	if _, err := cb.AddEntry(strings.Repeat("a", MaxEntrySize+1)); err != ErrTooLarge {
+		t.Errorf("AddEntry: got %+v, want ErrTooLarge", err)
+	}
+	if _, err := cb.EditEntry(0, strings.Repeat("a", MaxEntrySize+1)); err != ErrTooLarge {
+		t.Errorf("EditEntry: got %+v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Package myconfig layers the goshs settings. Defaults are overridden by the
+// config file, which is overridden by GOSHS_* environment variables, which are
+// overridden by flags given on the command line.
+package myconfig
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables
+const EnvPrefix = "GOSHS_"
+
+// usersKey holds the users of basic auth as name: hash
+const usersKey = "auth.users"
+
+// Setting ties a dotted key of the config file (tls.acme.email) to a flag.
+// The environment variable is derived from the key (GOSHS_TLS_ACME_EMAIL).
+type Setting struct {
+	Key  string
+	Flag string
+}
+
+// Config is the merged configuration
+type Config struct {
+	// File is the config file used, empty if there is none
+	File string
+	// Users are the basic auth users from the config file or GOSHS_AUTH_USERS
+	Users map[string]string
+
+	fset     *flag.FlagSet
+	settings []Setting
+}
+
+// Merge will apply the config file and the environment to the flags of fset,
+// which has to be parsed already. Flags given on the command line win.
+// Without file the default locations are searched.
+func Merge(fset *flag.FlagSet, settings []Setting, file string) (*Config, error) {
+	c := &Config{fset: fset, settings: settings, Users: make(map[string]string)}
+
+	// Remember the command line to apply it again at last
+	explicit := make(map[string]string)
+	fset.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if file == "" {
+		file = discover()
+	}
+	if file != "" {
+		values, err := load(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %+v", file, err)
+		}
+		if err := c.apply(values, "config file "+file); err != nil {
+			return nil, err
+		}
+		c.File = file
+	}
+
+	values := make(map[string]string)
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(EnvName(s.Key)); ok {
+			values[s.Key] = v
+		}
+	}
+	if v, ok := os.LookupEnv(EnvName(usersKey)); ok {
+		// The environment replaces the users of the file instead of adding to them
+		c.Users = make(map[string]string)
+		for _, user := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(user), ":", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid user %q in %s, use name:hash", user, EnvName(usersKey))
+			}
+			values[usersKey+"."+kv[0]] = kv[1]
+		}
+	}
+	if err := c.apply(values, "environment"); err != nil {
+		return nil, err
+	}
+
+	for name, value := range explicit {
+		if err := fset.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// EnvName will return the environment variable of a key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// Dump will return the effective configuration as yaml, usable as config file
+func (c *Config) Dump() ([]byte, error) {
+	root := yaml.MapSlice{}
+	for _, s := range c.settings {
+		f := c.fset.Lookup(s.Flag)
+		if f == nil {
+			continue
+		}
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		root = insert(root, strings.Split(s.Key, "."), value)
+	}
+
+	if len(c.Users) > 0 {
+		names := make([]string, 0, len(c.Users))
+		for name := range c.Users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		users := yaml.MapSlice{}
+		for _, name := range names {
+			users = append(users, yaml.MapItem{Key: name, Value: c.Users[name]})
+		}
+		root = insert(root, strings.Split(usersKey, "."), users)
+	}
+
+	out, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+	if c.File != "" {
+		out = append([]byte(fmt.Sprintf("# merged with %s\n", c.File)), out...)
+	}
+	return out, nil
+}
+
+// apply will set the flags for values, source is used in errors
+func (c *Config) apply(values map[string]string, source string) error {
+	flags := make(map[string]string)
+	for _, s := range c.settings {
+		flags[s.Key] = s.Flag
+	}
+
+	for key, value := range values {
+		if strings.HasPrefix(key, usersKey+".") {
+			c.Users[strings.TrimPrefix(key, usersKey+".")] = value
+			continue
+		}
+		name, ok := flags[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %s in %s", key, source)
+		}
+		if err := c.fset.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s in %s: %+v", key, source, err)
+		}
+	}
+
+	return nil
+}
+
+// discover will return GOSHS_CONFIG if set, or the config file in the user
+// config directory if there is one. The working directory is not searched as
+// it is the default webroot, where anybody able to upload could place one.
+func discover() string {
+	if file, ok := os.LookupEnv(EnvPrefix + "CONFIG"); ok {
+		return file
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	candidate := filepath.Join(dir, "goshs", "goshs.yaml")
+	if _, err := os.Stat(candidate); err == nil {
+		return candidate
+	}
+	return ""
+}
+
+// load will read the yaml file into dotted keys with string values
+func load(file string) (map[string]string, error) {
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the file is given by the user starting goshs
+	// #nosec G304
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[interface{}]interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	flatten("", tree, values)
+	return values, nil
+}
+
+// flatten will turn nested maps into dotted keys. Lists become comma separated values.
+func flatten(prefix string, tree map[interface{}]interface{}, values map[string]string) {
+	for k, v := range tree {
+		key := fmt.Sprint(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			flatten(key, v, values)
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			// Empty sections or values keep the default
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// insert will set the value at the path of keys in the nested map slice
+func insert(m yaml.MapSlice, keys []string, value interface{}) yaml.MapSlice {
+	if len(keys) == 1 {
+		return append(m, yaml.MapItem{Key: keys[0], Value: value})
+	}
+
+	for i, item := range m {
+		if item.Key == keys[0] {
+			if sub, ok := item.Value.(yaml.MapSlice); ok {
+				m[i].Value = insert(sub, keys[1:], value)
+				return m
+			}
+		}
+	}
+	return append(m, yaml.MapItem{Key: keys[0], Value: insert(yaml.MapSlice{}, keys[1:], value)})
+}
@@ -0,0 +1,222 @@
+package myconfig
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSettings = []Setting{
+	{Key: "port", Flag: "p"},
+	{Key: "webroot", Flag: "d"},
+	{Key: "tus_expiry", Flag: "te"},
+	{Key: "tls.enabled", Flag: "s"},
+	{Key: "tls.names", Flag: "ssn"},
+}
+
+// flags will return a parsed flag set like the one of goshs
+func flags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fset := flag.NewFlagSet("goshs", flag.ContinueOnError)
+	fset.Int("p", 8000, "port")
+	fset.String("d", ".", "web root")
+	fset.Duration("te", 24*time.Hour, "tus expiry")
+	fset.Bool("s", false, "tls")
+	fset.String("ssn", "", "self-signed names")
+	if err := fset.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fset
+}
+
+// setenv will set or with an empty value unset an environment variable for the test
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+// isolate will keep the environment and the user config dir of the machine out of the test
+func isolate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	setenv(t, "XDG_CONFIG_HOME", dir)
+	setenv(t, "HOME", dir)
+	setenv(t, "AppData", dir)
+	for _, key := range []string{"CONFIG", "PORT", "WEBROOT", "TUS_EXPIRY", "TLS_ENABLED", "TLS_NAMES", "AUTH_USERS"} {
+		setenv(t, EnvPrefix+key, "")
+	}
+	return dir
+}
+
+func writeConfig(t *testing.T, file, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPrecedence(t *testing.T) {
+	dir := isolate(t)
+	file := writeConfig(t, filepath.Join(dir, "goshs.yaml"), `
+port: 9000
+webroot: /srv/files
+tus_expiry: 1h
+tls:
+  enabled: true
+  names: [a.example, b.example]
+auth:
+  users:
+    alice: plain
+`)
+	setenv(t, "GOSHS_WEBROOT", "/srv/env")
+	setenv(t, "GOSHS_TUS_EXPIRY", "2h")
+
+	fset := flags(t, "-te", "3h")
+	c, err := Merge(fset, testSettings, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"p":   "9000",
+		"d":   "/srv/env",
+		"te":  "3h0m0s",
+		"s":   "true",
+		"ssn": "a.example,b.example",
+	}
+	for name, value := range want {
+		if got := fset.Lookup(name).Value.String(); got != value {
+			t.Errorf("-%s = %s, want %s", name, got, value)
+		}
+	}
+	if c.File != file || c.Users["alice"] != "plain" {
+		t.Errorf("unexpected config %+v", c)
+	}
+}
+
+func TestEnvironmentUsers(t *testing.T) {
+	isolate(t)
+	setenv(t, "GOSHS_AUTH_USERS", "alice:plain, bob:{SHA}abc=")
+	c, err := Merge(flags(t), testSettings, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Users["alice"] != "plain" || c.Users["bob"] != "{SHA}abc=" {
+		t.Errorf("Users = %+v", c.Users)
+	}
+
+	// The environment replaces the users of the file
+	file := writeConfig(t, filepath.Join(t.TempDir(), "goshs.yaml"), "auth:\n  users:\n    carol: plain\n")
+	setenv(t, "GOSHS_AUTH_USERS", "alice:plain")
+	c, err = Merge(flags(t), testSettings, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Users) != 1 || c.Users["alice"] != "plain" {
+		t.Errorf("Users = %+v, want alice only", c.Users)
+	}
+
+	setenv(t, "GOSHS_AUTH_USERS", "alice")
+	if _, err := Merge(flags(t), testSettings, ""); err == nil {
+		t.Error("user without hash: no error")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := isolate(t)
+
+	// The working directory is the default webroot and never searched
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd := t.TempDir()
+	writeConfig(t, filepath.Join(cwd, "goshs.yaml"), "port: 1\n")
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if file := discover(); file != "" {
+		t.Errorf("discover() = %s from the working directory", file)
+	}
+
+	user := writeConfig(t, filepath.Join(dir, "goshs", "goshs.yaml"), "port: 2\n")
+	configDir, err := os.UserConfigDir()
+	if err != nil || !strings.HasPrefix(configDir, dir) {
+		t.Skip("user config dir can not be redirected on this platform")
+	}
+	if file := discover(); file != user {
+		t.Errorf("discover() = %s, want %s", file, user)
+	}
+
+	setenv(t, "GOSHS_CONFIG", "/etc/goshs.yaml")
+	if file := discover(); file != "/etc/goshs.yaml" {
+		t.Errorf("discover() = %s, want GOSHS_CONFIG", file)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	dir := isolate(t)
+	tests := map[string]string{
+		"unknown": "color: blue\n",
+		"value":   "port: many\n",
+		"syntax":  "port: [\n",
+	}
+	for name, content := range tests {
+		file := writeConfig(t, filepath.Join(dir, name+".yaml"), content)
+		if _, err := Merge(flags(t), testSettings, file); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := Merge(flags(t), testSettings, filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestDump(t *testing.T) {
+	isolate(t)
+	c, err := Merge(flags(t, "-p", "9000", "-s"), testSettings, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The dump is a config file giving the same settings
+	file := writeConfig(t, filepath.Join(t.TempDir(), "dump.yaml"), string(out))
+	fset := flags(t)
+	if _, err := Merge(fset, testSettings, file); err != nil {
+		t.Fatalf("dump is no valid config: %+v\n%s", err, out)
+	}
+	if fset.Lookup("p").Value.String() != "9000" || fset.Lookup("s").Value.String() != "true" {
+		t.Errorf("dump lost settings:\n%s", out)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("tls.acme.email"); got != "GOSHS_TLS_ACME_EMAIL" {
+		t.Errorf("EnvName = %s", got)
+	}
+}
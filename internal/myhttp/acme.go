@@ -0,0 +1,66 @@
+package myhttp
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/patrickhener/goshs/internal/myca"
+)
+
+// setupACME will return the tls config getting certificates from the ACME server.
+// TLS-ALPN-01 challenges are answered on the https port, HTTP-01 challenges on
+// ACMEHTTPPort unless it is 0.
+func (fs *FileServer) setupACME() (*tls.Config, error) {
+	manager, err := myca.ACME(myca.ACMEConfig{
+		Domains:      fs.ACMEDomains,
+		DirectoryURL: fs.ACMEDirectory,
+		Email:        fs.ACMEEmail,
+		CacheDir:     fs.ACMECache,
+		RootCA:       fs.ACMERootCA,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := manager.TLSConfig()
+	tlsConf.MinVersion = tls.VersionTLS12
+
+	// The challenge server is started with the listeners
+	if fs.ACMEHTTPPort != 0 {
+		// Everything but challenges is redirected to https
+		fs.acmeHandler = manager.HTTPHandler(http.HandlerFunc(fs.redirectHTTPS))
+	}
+	log.Printf("Certificates from %+v are requested on the first connection and renewed automatically\n", manager.Client.DirectoryURL)
+
+	return tlsConf, nil
+}
+
+// listenACME will open the port answering ACME HTTP-01 challenges, nil if there is none
+func (fs *FileServer) listenACME() *listener {
+	if fs.acmeHandler == nil {
+		return nil
+	}
+	listeners, err := fs.open("http://" + net.JoinHostPort(fs.IP, strconv.Itoa(fs.ACMEHTTPPort)))
+	if err != nil {
+		// TLS-ALPN-01 still works without it
+		log.Printf("ERROR: Unable to answer ACME HTTP-01 challenges: %+v", err)
+		return nil
+	}
+	log.Printf("Answering ACME HTTP-01 challenges on %+v\n", listeners[0].name)
+	return &listeners[0]
+}
+
+// redirectHTTPS will send the client to the same url on the https port
+func (fs *FileServer) redirectHTTPS(w http.ResponseWriter, req *http.Request) {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	if fs.httpsPort != 0 && fs.httpsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(fs.httpsPort))
+	}
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusFound)
+}
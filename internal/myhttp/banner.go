@@ -0,0 +1,118 @@
+package myhttp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/mdp/qrterminal"
+)
+
+// reachableURL is an url goshs can be reached at and the interface it belongs to
+type reachableURL struct {
+	iface string
+	url   string
+	// loopback urls get no QR code as other devices cannot reach them
+	loopback bool
+}
+
+// banner will log the urls goshs is reachable at per interface, with the
+// fingerprint for https and QR codes if enabled
+func (fs *FileServer) banner(listeners []listener) {
+	var urls []reachableURL
+	tlsUsed := false
+	for _, l := range listeners {
+		addr, ok := l.Addr().(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		scheme := "http"
+		if l.tls {
+			scheme = "https"
+			tlsUsed = true
+		}
+		if l.tls && len(fs.ACMEDomains) > 0 {
+			// ACME certificates are only valid for the domains
+			for _, domain := range fs.ACMEDomains {
+				urls = append(urls, reachableURL{iface: "acme", url: formatURL(scheme, domain, addr.Port)})
+			}
+			continue
+		}
+		urls = append(urls, interfaceURLs(scheme, addr)...)
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	width := 0
+	for _, u := range urls {
+		if len(u.iface) > width {
+			width = len(u.iface)
+		}
+	}
+
+	log.Println("INFO:  Reachable at")
+	for _, u := range urls {
+		log.Printf("INFO:    %-*s %+v\n", width, u.iface, u.url)
+		if fs.QRCode && !u.loopback {
+			qrterminal.GenerateHalfBlock(u.url, qrterminal.L, log.Writer())
+		}
+	}
+	if tlsUsed && fs.fingerprint != "" {
+		log.Printf("INFO:  SHA-256 Fingerprint: %+v\n", fs.fingerprint)
+	}
+}
+
+// interfaceURLs will return the urls of all interfaces if addr is unspecified,
+// otherwise the url of addr and the interface it belongs to
+func interfaceURLs(scheme string, addr *net.TCPAddr) []reachableURL {
+	var urls []reachableURL
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("ERROR: Unable to list network interfaces: %+v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			// Link local addresses would need the zone
+			if !ok || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if !addr.IP.IsUnspecified() && !addr.IP.Equal(ipnet.IP) {
+				continue
+			}
+			urls = append(urls, reachableURL{
+				iface:    iface.Name,
+				url:      formatURL(scheme, ipnet.IP.String(), addr.Port),
+				loopback: ipnet.IP.IsLoopback(),
+			})
+		}
+	}
+
+	// Not an address of a local interface, e.g. behind a NAT
+	if len(urls) == 0 && !addr.IP.IsUnspecified() {
+		urls = append(urls, reachableURL{iface: "-", url: formatURL(scheme, addr.IP.String(), addr.Port), loopback: addr.IP.IsLoopback()})
+	}
+
+	return urls
+}
+
+// formatURL will return the url of host and port, leaving out default ports
+func formatURL(scheme, host string, port int) string {
+	if (scheme == "http" && port == 80) || (scheme == "https" && port == 443) {
+		if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		return fmt.Sprintf("%s://%s/", scheme, host)
+	}
+	return fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
@@ -0,0 +1,250 @@
+package myhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/mylog"
+	"github.com/patrickhener/goshs/internal/myutils"
+)
+
+// fileOpsPrefix is where the file management endpoints live
+const fileOpsPrefix = "/7079731d08e33a51e7987270bebb5dcf6e128e016c43cba52b1af91bd195ab86/"
+
+// errCrossOrigin is returned for requests which might be forged by another site
+var errCrossOrigin = errors.New("Cross-origin request rejected, send X-Requested-With")
+
+// fileOps handles delete, rename (move) and mkdir.
+// All of them take plain url paths as form values:
+//
+//	delete  file=/path (multiple)
+//	rename  from=/path to=newname or to=/new/path
+//	mkdir   dir=/parent name=newdir
+func (fs *FileServer) fileOps(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		fs.fileOpsError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		fs.fileOpsError(w, req, errCrossOrigin, http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		fs.fileOpsError(w, req, err, http.StatusBadRequest)
+		return
+	}
+
+	var back string
+	var status int
+	var err error
+	switch strings.TrimPrefix(req.URL.Path, fileOpsPrefix) {
+	case "delete":
+		back, status, err = fs.deleteFiles(req, req.PostForm["file"])
+	case "rename":
+		back, status, err = fs.rename(req, req.PostFormValue("from"), req.PostFormValue("to"))
+	case "mkdir":
+		back, status, err = fs.mkdir(req, req.PostFormValue("dir"), req.PostFormValue("name"))
+	default:
+		status, err = http.StatusNotFound, errors.New("unknown file operation")
+	}
+	if err != nil {
+		fs.fileOpsError(w, req, err, status)
+		return
+	}
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+
+	// Scripts get json, browsers go back to the directory
+	if wantsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+			log.Printf("ERROR: Error writing response to browser: %+v", err)
+		}
+		return
+	}
+	http.Redirect(w, req, back, http.StatusSeeOther)
+}
+
+// deleteFiles will remove files and directories (recursively)
+func (fs *FileServer) deleteFiles(req *http.Request, files []string) (string, int, error) {
+	if len(files) == 0 {
+		return "", http.StatusBadRequest, errors.New("You need to select a file before you can delete it")
+	}
+
+	// Check everything first so that nothing is deleted if one file is denied
+	var targets []string
+	for _, file := range files {
+		target, err := cleanTarget(file)
+		if err != nil {
+			return "", http.StatusBadRequest, err
+		}
+		if status, err := fs.deletable(req.Context(), target); err != nil {
+			return "", status, err
+		}
+		targets = append(targets, target)
+	}
+
+	for _, target := range targets {
+		if err := os.RemoveAll(fs.diskPath(target)); err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("Unable to delete %s: %+v", target, err)
+		}
+		log.Printf("INFO:  %s deleted %s", requestUser(req), target)
+	}
+
+	return path.Dir(targets[0]), http.StatusOK, nil
+}
+
+// rename will rename or move a file or directory within the webroot.
+// A target without slash is a new name in the same directory.
+func (fs *FileServer) rename(req *http.Request, from, to string) (string, int, error) {
+	source, err := cleanTarget(from)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if to == "" {
+		return "", http.StatusBadRequest, errors.New("A new name is needed")
+	}
+	if !strings.Contains(to, "/") {
+		to = path.Join(path.Dir(source), to)
+	}
+	target, err := cleanTarget(to)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if target == source {
+		return path.Dir(source), http.StatusOK, nil
+	}
+	if strings.HasPrefix(target, source+"/") {
+		return "", http.StatusBadRequest, errors.New("A directory can not be moved into itself")
+	}
+	if fs.mounts.Lookup(source) != fs.mounts.Lookup(target) {
+		return "", http.StatusBadRequest, errors.New("Files can not be moved between mounts")
+	}
+
+	if status, err := fs.deletable(req.Context(), source); err != nil {
+		return "", status, err
+	}
+	if _, err := os.Lstat(fs.diskPath(target)); err == nil {
+		return "", http.StatusConflict, fmt.Errorf("%s already exists", target)
+	}
+	if stat, err := os.Stat(fs.diskPath(path.Dir(target))); err != nil || !stat.IsDir() {
+		return "", http.StatusNotFound, fmt.Errorf("directory %s does not exist", path.Dir(target))
+	}
+	if !fs.allowed(req, target, myacl.Upload) {
+		return "", http.StatusForbidden, fmt.Errorf("You are not allowed to create %s", target)
+	}
+
+	if err := os.Rename(fs.diskPath(source), fs.diskPath(target)); err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Unable to move %s: %+v", source, err)
+	}
+	log.Printf("INFO:  %s moved %s to %s", requestUser(req), source, target)
+
+	return path.Dir(source), http.StatusOK, nil
+}
+
+// mkdir will create a new directory
+func (fs *FileServer) mkdir(req *http.Request, dir, name string) (string, int, error) {
+	parent := path.Clean("/" + dir)
+	if internalPath(parent) {
+		return "", http.StatusNotFound, fmt.Errorf("directory %s does not exist", parent)
+	}
+	name, err := sanitizeFilename(name)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	target := path.Join(parent, name)
+	if myutils.CheckSpecialPath(name) || (fs.ACLDirFiles && myacl.IsDirFile(target)) {
+		return "", http.StatusBadRequest, fmt.Errorf("%s is reserved", name)
+	}
+
+	if stat, err := os.Stat(fs.diskPath(parent)); err != nil || !stat.IsDir() {
+		return "", http.StatusNotFound, fmt.Errorf("directory %s does not exist", parent)
+	}
+	if !fs.allowed(req, target, myacl.Upload) {
+		return "", http.StatusForbidden, fmt.Errorf("You are not allowed to create %s", target)
+	}
+
+	// disable G301 (CWE-276): Expect directory permissions to be 0750 or less
+	// as the directory is meant to be shared
+	// #nosec G301
+	if err := os.Mkdir(fs.diskPath(target), 0755); err != nil {
+		if os.IsExist(err) {
+			return "", http.StatusConflict, fmt.Errorf("%s already exists", target)
+		}
+		return "", http.StatusInternalServerError, fmt.Errorf("Unable to create %s: %+v", target, err)
+	}
+	log.Printf("INFO:  %s created directory %s", requestUser(req), target)
+
+	return parent, http.StatusOK, nil
+}
+
+// deletable will check that the user stored in the context may delete target and everything below it
+func (fs *FileServer) deletable(ctx context.Context, target string) (int, error) {
+	root := fs.diskPath(target)
+	if _, err := os.Lstat(root); err != nil {
+		return http.StatusNotFound, fmt.Errorf("%s does not exist", target)
+	}
+
+	return http.StatusForbidden, filepath.Walk(root, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		upath := path.Join(target, filepath.ToSlash(strings.TrimPrefix(fpath, root)))
+		if !fs.allowedCtx(ctx, upath, myacl.Delete) {
+			return fmt.Errorf("You are not allowed to delete %s", upath)
+		}
+		return nil
+	})
+}
+
+// sameOrigin will protect state changing requests against cross-site request forgery.
+// Other sites can only send X-Requested-With after a CORS preflight, which goshs never
+// answers, and browsers add the Origin header to every cross-origin post.
+func sameOrigin(req *http.Request) bool {
+	if req.Header.Get("X-Requested-With") != "" {
+		return true
+	}
+	origin, err := url.Parse(req.Header.Get("Origin"))
+	return err == nil && origin.Host != "" && origin.Host == req.Host
+}
+
+// diskPath will return the path on disk for a clean url path,
+// empty for the listing of mounts or paths outside of every mount
+func (fs *FileServer) diskPath(upath string) string {
+	return fs.mounts.Resolve(upath)
+}
+
+// fileOpsError answers with json or the error page depending on the client
+func (fs *FileServer) fileOpsError(w http.ResponseWriter, req *http.Request, err error, status int) {
+	if wantsJSON(req) {
+		fs.jsonError(w, req, err, status)
+		return
+	}
+	fs.handleError(w, req, err, status)
+}
+
+// cleanTarget will make a client supplied path safe to use below the webroot (No path traversal)
+func cleanTarget(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", errors.New("invalid path")
+	}
+	clean := path.Clean("/" + p)
+	if clean == "/" {
+		return "", errors.New("The web root itself can not be changed")
+	}
+	first := strings.SplitN(strings.TrimPrefix(clean, "/"), "/", 2)[0]
+	if internalPath(clean) || myutils.CheckSpecialPath(first) {
+		return "", fmt.Errorf("%s does not exist", clean)
+	}
+	return clean, nil
+}
@@ -2,27 +2,39 @@ package myhttp
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/myauth"
 	"github.com/patrickhener/goshs/internal/myca"
 	"github.com/patrickhener/goshs/internal/myclipboard"
 	"github.com/patrickhener/goshs/internal/mylog"
+	"github.com/patrickhener/goshs/internal/mymount"
+	"github.com/patrickhener/goshs/internal/myshare"
 	"github.com/patrickhener/goshs/internal/mysock"
 	"github.com/patrickhener/goshs/internal/myutils"
+	"github.com/patrickhener/goshs/internal/mywatch"
 
 	"github.com/phogolabs/parcello"
 
@@ -31,47 +43,111 @@ import (
 )
 
 type indexTemplate struct {
-	Clipboard    *myclipboard.Clipboard
-	GoshsVersion string
-	Directory    *directory
+	Clipboard     *myclipboard.Clipboard
+	GoshsVersion  string
+	Directory     *directory
+	NoDestructive bool
 }
 
 type directory struct {
-	RelPath        string
-	AbsPath        string
-	IsSubdirectory bool
-	Back           string
-	Content        []item
+	RelPath        string `json:"path"`
+	AbsPath        string `json:"-"`
+	IsSubdirectory bool   `json:"-"`
+	Back           string `json:"-"`
+	Content        []item `json:"content"`
 }
 
 type item struct {
-	URI                 string
-	Name                string
-	IsDir               bool
-	IsSymlink           bool
-	SymlinkTarget       string
-	Ext                 string
-	DisplaySize         string
-	SortSize            int64
-	DisplayLastModified string
-	SortLastModified    time.Time
+	URI                 string    `json:"uri"`
+	Path                string    `json:"path"`
+	Name                string    `json:"name"`
+	IsDir               bool      `json:"is_dir"`
+	IsSymlink           bool      `json:"is_symlink"`
+	SymlinkTarget       string    `json:"symlink_target,omitempty"`
+	Ext                 string    `json:"ext"`
+	DisplaySize         string    `json:"-"`
+	SortSize            int64     `json:"size"`
+	DisplayLastModified string    `json:"-"`
+	SortLastModified    time.Time `json:"mtime"`
+	Children            []item    `json:"children,omitempty"`
 }
 
 // FileServer holds the fileserver information
 type FileServer struct {
-	IP         string
-	Port       int
-	Webroot    string
-	SSL        bool
-	SelfSigned bool
-	MyKey      string
-	MyCert     string
-	BasicAuth  string
-	Version    string
-	Hub        *mysock.Hub
-	Clipboard  *myclipboard.Clipboard
+	IP             string
+	Port           int
+	Webroot        string
+	SSL            bool
+	SelfSigned     bool
+	MyKey          string
+	MyCert         string
+	CADir          string
+	CertOptions    myca.Options
+	ClientCA       string
+	ClientCertUser string
+	ACMEDomains    []string
+	ACMEDirectory  string
+	ACMEEmail      string
+	ACMECache      string
+	ACMERootCA     string
+	ACMEHTTPPort   int
+	BasicAuth      string
+	AuthFile       string
+	AuthUsers      map[string]string
+	ACLFile        string
+	ACLDirFiles    bool
+	MaxUpload      int64
+	TusExpiry      time.Duration
+	TusDir         string
+	WebDAV         bool
+	WebDAVPort     int
+	Mounts         []mymount.Mount
+	Listeners      []string
+	QRCode         bool
+	NoDestructive  bool
+	ClipboardFile  string
+	ConfigFile     string
+	Version        string
+	Hub            *mysock.Hub
+	Clipboard      *myclipboard.Clipboard
+	Users          *myauth.Users
+	ACL            *myacl.ACL
+	Shares         *myshare.Store
+	CA             *myca.CA
+	DrainTimeout   time.Duration
+	tusStore       *tusStore
+	watcher        *mywatch.Watcher
+	reloader       *myca.Reloader
+	mu             sync.Mutex
+	servers        []*http.Server
+	router         *mux.Router
+	tlsConfig      *tls.Config
+	tlsInfo        string
+	fingerprint    string
+	mounts         *mymount.Table
+	operatorFiles  []string
+	operatorDirs   []string
+	acmeHandler    http.Handler
+	httpsPort      int
 }
 
+// caPrefix is where the certificate of the self-signed CA can be downloaded
+const caPrefix = "/6959097001d10501ac7d54c0bdb8db61420f658f2922cc26e46d536119a31126/"
+
+// defaultDrainTimeout is how long running requests may take on shutdown if DrainTimeout is not set
+const defaultDrainTimeout = 30 * time.Second
+
+// maxClipboardImport is the maximum size of a clipboard export to import
+const maxClipboardImport = 10 << 20
+
+type contextKey int
+
+// userKey is the request context key holding the authenticated username
+const userKey contextKey = iota
+
+// webdavPrefix is where WebDAV is served if it shares the port with the file server
+const webdavPrefix = "/webdav"
+
 type httperror struct {
 	ErrorCode    int
 	ErrorMessage string
@@ -82,6 +158,17 @@ type httperror struct {
 // BasicAuthMiddleware is a middleware to handle the basic auth
 func (fs *FileServer) BasicAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A client certificate already identified the user
+		if _, ok := r.Context().Value(userKey).(string); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 
 		username, password, authOK := r.BasicAuth()
@@ -90,18 +177,243 @@ func (fs *FileServer) BasicAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if username != "gopher" || password != fs.BasicAuth {
+		if !fs.Users.Verify(username, password) {
+			mylog.LogRequest(r.RemoteAddr, username, r.Method, r.URL.Path, r.Proto, http.StatusUnauthorized)
 			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Remember the user for logging
+		ctx := context.WithValue(r.Context(), userKey, username)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 
 }
 
-// Start will start the file server
-func (fs *FileServer) Start() {
+// publicPath will check if the url path is served without authentication.
+// Share links carry their own token (and password), the CA certificate is public.
+func publicPath(upath string) bool {
+	return strings.HasPrefix(upath, sharePrefix+"get/") || strings.HasPrefix(upath, caPrefix)
+}
+
+// setupAuth will construct the user database from -P and the htpasswd file
+func (fs *FileServer) setupAuth() error {
+	if fs.AuthFile != "" {
+		users, err := myauth.LoadHtpasswd(fs.AuthFile)
+		if err != nil {
+			return err
+		}
+		fs.Users = users
+	}
+
+	// Users of the config file are added to the htpasswd file
+	if len(fs.AuthUsers) > 0 {
+		if fs.Users == nil {
+			fs.Users = myauth.New()
+		}
+		for name, hash := range fs.AuthUsers {
+			if err := fs.Users.Add(name, hash); err != nil {
+				return fmt.Errorf("invalid user in the configuration: %+v", err)
+			}
+		}
+	}
+
+	if fs.BasicAuth != "" {
+		if fs.Users == nil {
+			fs.Users = myauth.New()
+		}
+		if err := fs.Users.AddPassword(myauth.DefaultUser, fs.BasicAuth); err != nil {
+			return err
+		}
+	}
+
+	if fs.Users != nil && fs.Users.Len() == 0 {
+		return fmt.Errorf("no users found in %s", fs.AuthFile)
+	}
+
+	return nil
+}
+
+// allowed will check the access control list for the request user
+func (fs *FileServer) allowed(req *http.Request, upath string, perm myacl.Permission) bool {
+	return fs.allowedCtx(req.Context(), upath, perm)
+}
+
+// allowedCtx will check the mounts and the access control list for the user stored in the context
+func (fs *FileServer) allowedCtx(ctx context.Context, upath string, perm myacl.Permission) bool {
+	// Nothing can be deleted or overwritten at all
+	if fs.NoDestructive && (perm == myacl.Delete || perm == myacl.Overwrite) {
+		return false
+	}
+	if fs.mounts != nil && fs.mounts.Virtual() {
+		upath = path.Clean("/" + upath)
+		m := fs.mounts.Lookup(upath)
+		switch {
+		case m == nil:
+			// Only the listing of mounts is there
+			return upath == "/" && (perm == myacl.List || perm == myacl.Read)
+		case upath == m.Path && (perm == myacl.Delete || perm == myacl.Overwrite):
+			// Mounts can not be removed or renamed
+			return false
+		case m.ReadOnly && perm != myacl.Read && perm != myacl.List:
+			return false
+		case m.UploadOnly && (perm == myacl.Delete || perm == myacl.Overwrite):
+			return false
+		}
+	}
+	// The files of the operator may hold secrets and must not be replaced
+	if fs.operatorFile(upath) {
+		return false
+	}
+	if fs.ACL == nil {
+		return true
+	}
+	// Never expose or accept the per directory policy files
+	if fs.ACLDirFiles && myacl.IsDirFile(upath) {
+		return false
+	}
+	user, _ := ctx.Value(userKey).(string)
+	return fs.ACL.Allowed(user, upath, perm)
+}
+
+// setupOperatorFiles will remember the absolute paths of the files and directories given by the operator
+func (fs *FileServer) setupOperatorFiles() error {
+	files := []string{fs.ConfigFile, fs.AuthFile, fs.ACLFile, fs.ClipboardFile, fs.MyKey, fs.MyCert, fs.ACMERootCA}
+	if fs.ClientCA != ClientCAGoshs {
+		files = append(files, fs.ClientCA)
+	}
+	dirs := []string{fs.CADir, fs.ACMECache}
+
+	abs := func(names []string) ([]string, error) {
+		var result []string
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			name, err := filepath.Abs(name)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, name)
+		}
+		return result, nil
+	}
+
+	var err error
+	if fs.operatorFiles, err = abs(files); err != nil {
+		return err
+	}
+	fs.operatorDirs, err = abs(dirs)
+	return err
+}
+
+// operatorFile will check if the url path is a file given by the operator like the config file,
+// htpasswd, policy, clipboard or keys, or lies in the CA or ACME directory. Files are compared by
+// identity, so that neither symlinks nor case insensitive file systems reveal them.
+func (fs *FileServer) operatorFile(upath string) bool {
+	if len(fs.operatorFiles) == 0 && len(fs.operatorDirs) == 0 {
+		return false
+	}
+	upath = path.Clean("/" + upath)
+
+	// The clipboard file is replaced on every save, so the files are looked at each time
+	if stat, err := os.Stat(fs.diskPath(upath)); err == nil && !stat.IsDir() {
+		for _, file := range fs.operatorFiles {
+			if other, err := os.Stat(file); err == nil && os.SameFile(stat, other) {
+				return true
+			}
+		}
+	}
+
+	var dirs []os.FileInfo
+	for _, dir := range fs.operatorDirs {
+		if stat, err := os.Stat(dir); err == nil {
+			dirs = append(dirs, stat)
+		}
+	}
+	if len(dirs) == 0 {
+		return false
+	}
+
+	// The directories are searched above the real path, paths which do not exist
+	// yet are resolved from their closest existing parent
+	disk := fs.diskPath(upath)
+	if disk == "" {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(disk)
+	for err != nil && disk != filepath.Dir(disk) {
+		disk = filepath.Dir(disk)
+		real, err = filepath.EvalSymlinks(disk)
+	}
+	if err != nil {
+		return false
+	}
+	for p := real; ; p = filepath.Dir(p) {
+		if stat, err := os.Stat(p); err == nil {
+			for _, dir := range dirs {
+				if os.SameFile(stat, dir) {
+					return true
+				}
+			}
+		}
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+
+	return false
+}
+
+// requestUser will return the authenticated user of a request or "-"
+func requestUser(req *http.Request) string {
+	if user, ok := req.Context().Value(userKey).(string); ok {
+		return user
+	}
+	return "-"
+}
+
+// Setup will prepare the router, the clipboard, the websocket hub, authentication,
+// access control and tls. It is called by Start, embedders call it before Handler or Serve.
+// It does not listen on any port, the background work it starts is stopped by Shutdown.
+func (fs *FileServer) Setup() (err error) {
+	if fs.router != nil {
+		return nil
+	}
+
+	// -i may be an interface name
+	ip, err := myutils.ResolveHost(fs.IP)
+	if err != nil {
+		return err
+	}
+	if ip != fs.IP {
+		log.Printf("INFO:  Using %+v of interface %+v\n", ip, fs.IP)
+		fs.IP = ip
+	}
+
+	// Serve the webroot or the mounted directories
+	mounts, err := mymount.New(fs.Webroot, fs.Mounts)
+	if err != nil {
+		return fmt.Errorf("Unable to mount directories: %+v", err)
+	}
+	fs.mounts = mounts
+	if mounts.Virtual() {
+		for _, m := range mounts.Mounts() {
+			mode := ""
+			if m.ReadOnly {
+				mode = " read only"
+			} else if m.UploadOnly {
+				mode = " upload only"
+			}
+			log.Printf("INFO:  Mounted %+v at %+v%+v\n", m.Dir, m.Path, mode)
+		}
+	}
+
+	// Files given by the operator are hidden if they are inside the served directories
+	if err := fs.setupOperatorFiles(); err != nil {
+		return err
+	}
+
 	// Setup routing with gorilla/mux
 	mux := mux.NewRouter()
 	mux.PathPrefix("/425bda8487e36deccb30dd24be590b8744e3a28a8bb5a57d9b3fcd24ae09ad3c/").HandlerFunc(fs.static)
@@ -109,78 +421,471 @@ func (fs *FileServer) Start() {
 	mux.PathPrefix("/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/ws").HandlerFunc(fs.socket)
 	// Clipboard
 	mux.PathPrefix("/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/download").HandlerFunc(fs.cbDown)
+	mux.PathPrefix("/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/import").HandlerFunc(fs.cbImport)
 	mux.PathPrefix("/cf985bddf28fed5d5c53b069d6a6ebe601088ca6e20ec5a5a8438f8e1ffd9390/").HandlerFunc(fs.bulkDownload)
+	// WebDAV on the same port
+	if fs.WebDAV && fs.WebDAVPort == 0 {
+		mux.PathPrefix(webdavPrefix + "/").Handler(fs.webdavHandler(webdavPrefix))
+	}
+	// Resumable uploads (tus)
+	mux.PathPrefix(tusPrefix).HandlerFunc(fs.tus)
+	mux.PathPrefix(fileOpsPrefix).HandlerFunc(fs.fileOps)
+	mux.PathPrefix(sharePrefix).HandlerFunc(fs.share)
+	mux.PathPrefix(caPrefix).HandlerFunc(fs.caCert)
 	mux.Methods(http.MethodPost).HandlerFunc(fs.upload)
+	mux.Methods(http.MethodPut).HandlerFunc(fs.put)
 	mux.PathPrefix("/").HandlerFunc(fs.handler)
 
-	// construct server
-	add := fmt.Sprintf("%+v:%+v", fs.IP, fs.Port)
-	server := http.Server{
-		Addr:    add,
-		Handler: mux,
-		// Good practice: enforce timeouts for servers you create!
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	// init tus staging area
+	tusDir := fs.TusDir
+	if tusDir == "" {
+		tusDir = defaultTusDir(fs.mounts)
 	}
+	fs.tusStore = newTusStore(tusDir, fs.TusExpiry)
 
 	// init clipboard
-	fs.Clipboard = myclipboard.New()
+	if fs.ClipboardFile != "" {
+		cb, err := myclipboard.Load(fs.ClipboardFile)
+		if err != nil {
+			return fmt.Errorf("Unable to load clipboard: %+v", err)
+		}
+		fs.Clipboard = cb
+	} else {
+		fs.Clipboard = myclipboard.New()
+	}
+
+	// init share links
+	fs.Shares = myshare.New()
 
 	// init websocket hub
 	fs.Hub = mysock.NewHub(fs.Clipboard)
+
+	// Watch the directories the browsers are looking at for live updates
+	watcher, err := mywatch.New(fs.mounts.Resolve, fs.Hub.DirChanged)
+	if err != nil {
+		log.Printf("ERROR: Unable to watch directories, listings will not update live: %+v", err)
+	} else {
+		fs.Hub.Watcher = watcher
+		fs.watcher = watcher
+	}
 	go fs.Hub.Run()
 
+	// Do not leave the hub and the watchers behind if the server does not come up
+	defer func() {
+		if err != nil {
+			fs.release(context.Background())
+		}
+	}()
+
+	// Client certificates identify the user before basic auth is checked
+	if fs.ClientCA != "" {
+		mux.Use(fs.ClientCertMiddleware)
+	}
+
 	// Check BasicAuth and use middleware
-	if fs.BasicAuth != "" {
+	if err := fs.setupAuth(); err != nil {
+		return fmt.Errorf("Unable to setup basic auth: %+v", err)
+	}
+	if fs.Users != nil {
 		if !fs.SSL {
 			log.Printf("WARNING!: You are using basic auth without SSL. Your credentials will be transferred in cleartext. Consider using -s, too.\n")
 		}
-		log.Printf("Using 'gopher:%+v' as basic auth\n", fs.BasicAuth)
+		if fs.BasicAuth != "" {
+			log.Printf("Using '%s:%+v' as basic auth\n", myauth.DefaultUser, fs.BasicAuth)
+		}
+		if fs.AuthFile != "" || len(fs.AuthUsers) > 0 {
+			source := fs.AuthFile
+			if source == "" {
+				source = "the configuration"
+			}
+			log.Printf("Using users from %+v as basic auth: %+v\n", source, strings.Join(fs.Users.Names(), ", "))
+		}
 		// Use middleware
 		mux.Use(fs.BasicAuthMiddleware)
 	}
 
+	// Setup access control lists
+	if fs.ACLFile != "" || fs.ACLDirFiles {
+		acl, err := myacl.New(fs.mounts.Resolve, fs.ACLFile, fs.ACLDirFiles)
+		if err != nil {
+			return fmt.Errorf("Unable to load access control list: %+v", err)
+		}
+		fs.ACL = acl
+		if fs.ACLFile != "" {
+			log.Printf("Using access control list from %+v\n", fs.ACLFile)
+		}
+		if fs.ACLDirFiles {
+			log.Printf("Using access control lists from per directory %+v files\n", myacl.DirFile)
+		}
+	}
+
 	// Check if ssl
 	if fs.SSL {
-		// Check if selfsigned
-		if fs.SelfSigned {
-			serverTLSConf, fingerprint256, fingerprint1, err := myca.Setup()
+		// Check if ACME or selfsigned
+		if len(fs.ACMEDomains) > 0 {
+			tlsConf, err := fs.setupACME()
+			if err != nil {
+				return fmt.Errorf("Unable to start SSL enabled server: %+v", err)
+			}
+			fs.tlsConfig = tlsConf
+			fs.tlsInfo = fmt.Sprintf("with ssl enabled and ACME certificate for %+v", strings.Join(fs.ACMEDomains, ", "))
+		} else if fs.SelfSigned {
+			// A CA kept in a directory is reused so that it has to be trusted only once
+			var ca *myca.CA
+			var err error
+			if fs.CADir != "" {
+				ca, err = myca.LoadCA(fs.CADir, fs.CertOptions)
+			} else {
+				ca, err = myca.NewCA(fs.CertOptions)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to start SSL enabled server: %+v", err)
+			}
+			fs.CA = ca
+
+			serverTLSConf, fingerprint256, fingerprint1, err := ca.ServerConfig()
 			if err != nil {
-				log.Fatalf("Unable to start SSL enabled server: %+v\n", err)
+				return fmt.Errorf("Unable to start SSL enabled server: %+v", err)
 			}
-			server.TLSConfig = serverTLSConf
-			log.Printf("Serving HTTPS on %+v port %+v from %+v with ssl enabled and self-signed certificate\n", fs.IP, fs.Port, fs.Webroot)
+			fs.tlsConfig = serverTLSConf
+			fs.tlsInfo = "with ssl enabled and self-signed certificate"
+			fs.fingerprint = fingerprint256
 			log.Println("WARNING! Be sure to check the fingerprint of certificate")
 			log.Printf("SHA-256 Fingerprint: %+v\n", fingerprint256)
 			log.Printf("SHA-1   Fingerprint: %+v\n", fingerprint1)
-			log.Panic(server.ListenAndServeTLS("", ""))
+			if fs.CADir != "" {
+				caFingerprint256, _ := myca.Sum(ca.Cert.Raw)
+				log.Printf("Using CA from %+v, download it at %+vca.pem or %+vca.der\n", fs.CADir, caPrefix, caPrefix)
+				log.Printf("CA SHA-256 Fingerprint: %+v\n", caFingerprint256)
+			}
 		} else {
 			if fs.MyCert == "" || fs.MyKey == "" {
-				log.Fatalln("You need to provide server.key and server.crt if -s and not -ss")
+				return errors.New("You need to provide server.key and server.crt if -s and not -ss")
 			}
 
 			fingerprint256, fingerprint1, err := myca.ParseAndSum(fs.MyCert)
 			if err != nil {
-				log.Fatalf("Unable to start SSL enabled server: %+v\n", err)
+				return fmt.Errorf("Unable to start SSL enabled server: %+v", err)
+			}
+
+			// The certificate is reloaded when the files change or on SIGHUP
+			reloader, err := myca.NewReloader(fs.MyCert, fs.MyKey)
+			if err != nil {
+				return fmt.Errorf("Unable to start SSL enabled server: %+v", err)
 			}
+			fs.reloader = reloader
+			fs.tlsConfig = &tls.Config{
+				GetCertificate: reloader.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			}
+			fs.tlsInfo = fmt.Sprintf("with ssl enabled server key: %+v, server cert: %+v", fs.MyKey, fs.MyCert)
+			fs.fingerprint = fingerprint256
 
-			log.Printf("Serving HTTPS on %+v port %+v from %+v with ssl enabled server key: %+v, server cert: %+v\n", fs.IP, fs.Port, fs.Webroot, fs.MyKey, fs.MyCert)
 			log.Println("INFO! You provided a certificate and might want to check the fingerprint nonetheless")
 			log.Printf("SHA-256 Fingerprint: %+v\n", fingerprint256)
 			log.Printf("SHA-1   Fingerprint: %+v\n", fingerprint1)
+		}
+	}
 
-			log.Panic(server.ListenAndServeTLS(fs.MyCert, fs.MyKey))
+	// Check if mutual tls
+	if fs.ClientCA != "" {
+		if err := fs.setupClientAuth(fs.tlsConfig); err != nil {
+			return fmt.Errorf("Unable to setup client certificates: %+v", err)
 		}
-	} else {
-		log.Printf("Serving HTTP on %+v port %+v from %+v\n", fs.IP, fs.Port, fs.Webroot)
-		log.Panic(server.ListenAndServe())
+		clientCA := fs.ClientCA
+		if clientCA == ClientCAGoshs {
+			clientCA = fs.CADir
+		}
+		user := fs.ClientCertUser
+		if user == "" {
+			user = "cn"
+		}
+		log.Printf("Requiring client certificates signed by %+v, the user is taken from the %+v\n", clientCA, user)
+	}
+
+	fs.router = mux
+
+	return nil
+}
+
+// Handler will return the handler serving goshs, Setup has to be called first.
+// It only works mounted at / as every path it serves and links to is absolute.
+func (fs *FileServer) Handler() http.Handler {
+	return fs.router
+}
+
+// TLSConfig will return the tls config after Setup, nil if goshs serves plain http
+func (fs *FileServer) TLSConfig() *tls.Config {
+	return fs.tlsConfig
+}
+
+// Serve will serve goshs on the listener, with tls if configured, until Shutdown is called.
+// Setup has to be called first. Like http.Server it returns http.ErrServerClosed after Shutdown.
+func (fs *FileServer) Serve(l net.Listener) error {
+	return fs.serve(listener{Listener: l, tls: fs.tlsConfig != nil}, fs.router)
+}
+
+// ListenAndServe will serve goshs on Listeners, or IP:Port if there are none, and WebDAV
+// on WebDAVPort if set until Shutdown is called. Setup has to be called first. If one
+// listener fails the others are shut down as well.
+func (fs *FileServer) ListenAndServe() error {
+	listeners, err := fs.listen()
+	if err != nil {
+		return err
+	}
+
+	type served struct {
+		listener
+		handler http.Handler
+	}
+	var servers []served
+	for _, l := range listeners {
+		scheme := "HTTP"
+		if l.tls {
+			scheme = "HTTPS"
+		}
+		if l.tls && fs.tlsInfo != "" {
+			log.Printf("Serving %+v on %+v from %+v %+v\n", scheme, l.name, fs.servedFrom(), fs.tlsInfo)
+		} else {
+			log.Printf("Serving %+v on %+v from %+v\n", scheme, l.name, fs.servedFrom())
+		}
+		servers = append(servers, served{l, fs.router})
+		// Redirects to https go to the first tcp port serving it
+		if addr, ok := l.Addr().(*net.TCPAddr); ok && l.tls && fs.httpsPort == 0 {
+			fs.httpsPort = addr.Port
+		}
+	}
+	fs.banner(listeners)
+
+	// Check if webdav
+	if fs.WebDAV {
+		if fs.WebDAVPort == 0 {
+			log.Printf("Serving WebDAV at %+v\n", webdavPrefix)
+		} else {
+			davListeners, err := fs.open(net.JoinHostPort(fs.IP, fmt.Sprint(fs.WebDAVPort)))
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				return err
+			}
+			// WebDAV on its own port shares auth and tls with the file server
+			var davHandler http.Handler = fs.webdavHandler("")
+			if fs.Users != nil {
+				davHandler = fs.BasicAuthMiddleware(davHandler)
+			}
+			if fs.ClientCA != "" {
+				davHandler = fs.ClientCertMiddleware(davHandler)
+			}
+			log.Printf("Serving WebDAV on %+v\n", davListeners[0].name)
+			servers = append(servers, served{davListeners[0], davHandler})
+		}
+	}
+
+	if l := fs.listenACME(); l != nil {
+		servers = append(servers, served{*l, fs.acmeHandler})
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server served) {
+			errs <- fs.serve(server.listener, server.handler)
+		}(server)
+	}
+
+	err = <-errs
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	// Do not keep the other listeners running
+	ctx, cancel := context.WithTimeout(context.Background(), fs.drainTimeout())
+	defer cancel()
+	// disable G104 (CWE-703): Errors unhandled
+	// as the error of the failed listener is more interesting
+	// #nosec G104
+	fs.Shutdown(ctx)
+	return err
+}
+
+// Start will set up goshs and serve it until SIGINT or SIGTERM. Running
+// transfers get DrainTimeout to finish, a second signal aborts them.
+func (fs *FileServer) Start() error {
+	if err := fs.Setup(); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fs.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		drain := fs.drainTimeout()
+		log.Printf("INFO:  Received %+v, shutting down, waiting up to %+v for running transfers (repeat to abort them)\n", sig, drain)
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
+		defer cancel()
+		go func() {
+			<-signals
+			cancel()
+		}()
+		// Aborting transfers is what the user asked for
+		if err := fs.Shutdown(ctx); err != nil && err != context.DeadlineExceeded && err != context.Canceled {
+			return err
+		}
+		return nil
+	}
+}
+
+// serve will serve handler on l until Shutdown is called
+func (fs *FileServer) serve(l listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler: handler,
+		// Good practice: enforce timeouts for servers you create!
+		// There is no read or write timeout for the whole request
+		// as this would kill long running up- and downloads
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	if l.tls {
+		server.TLSConfig = fs.tlsConfig
+	}
+
+	fs.mu.Lock()
+	fs.servers = append(fs.servers, server)
+	fs.mu.Unlock()
+
+	if l.tls {
+		return server.ServeTLS(l, "", "")
+	}
+	return server.Serve(l)
+}
+
+// drainTimeout will return how long running requests may take on shutdown
+func (fs *FileServer) drainTimeout() time.Duration {
+	if fs.DrainTimeout <= 0 {
+		return defaultDrainTimeout
+	}
+	return fs.DrainTimeout
+}
+
+// Shutdown will stop accepting connections and wait for running requests
+// until ctx is done. Requests still running then are aborted. Afterwards the
+// websocket clients are disconnected, everything Setup started is stopped and
+// the clipboard is saved.
+func (fs *FileServer) Shutdown(ctx context.Context) error {
+	fs.mu.Lock()
+	servers := fs.servers
+	fs.servers = nil
+	fs.mu.Unlock()
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				// disable G104 (CWE-703): Errors unhandled
+				// as the connections are dropped anyway
+				// #nosec G104
+				srv.Close()
+			}
+			errs <- err
+		}(srv)
+	}
+
+	// Websockets are not tracked by the servers, tell the browsers meanwhile
+	fs.release(ctx)
+
+	var err error
+	for range servers {
+		if serr := <-errs; serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		log.Println("WARNING! Aborted running transfers")
+	}
+
+	log.Println("INFO:  Shut down")
+	return err
+}
+
+// release will disconnect the websocket clients, stop watching and collecting
+// expired uploads and save the clipboard
+func (fs *FileServer) release(ctx context.Context) {
+	if fs.Hub != nil {
+		if err := fs.Hub.Close(ctx); err != nil {
+			log.Printf("ERROR: Not all websocket clients could be told about the shutdown: %+v", err)
+		}
+	}
+	if fs.watcher != nil {
+		if err := fs.watcher.Close(); err != nil {
+			log.Printf("ERROR: Error closing directory watcher: %+v", err)
+		}
+		fs.watcher = nil
+	}
+	if fs.reloader != nil {
+		if err := fs.reloader.Close(); err != nil {
+			log.Printf("ERROR: Error closing certificate watcher: %+v", err)
+		}
+		fs.reloader = nil
+	}
+	if fs.tusStore != nil {
+		fs.tusStore.close()
+	}
+	if fs.Clipboard != nil {
+		if err := fs.Clipboard.Save(); err != nil {
+			log.Printf("ERROR: %+v", err)
+		}
+	}
+}
+
+// caCert will deliver the certificate of the self-signed CA as ca.pem or ca.der
+func (fs *FileServer) caCert(w http.ResponseWriter, req *http.Request) {
+	if fs.CA == nil {
+		fs.handleError(w, req, errors.New("There is no self-signed CA"), http.StatusNotFound)
+		return
+	}
+
+	var content []byte
+	switch strings.TrimPrefix(req.URL.Path, caPrefix) {
+	case "ca.pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		content = fs.CA.PEM()
+	case "ca.der":
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		content = fs.CA.Cert.Raw
+	default:
+		fs.handleError(w, req, errors.New("Use ca.pem or ca.der"), http.StatusNotFound)
+		return
+	}
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"goshs-%s\"", path.Base(req.URL.Path)))
+	if _, err := w.Write(content); err != nil {
+		log.Printf("ERROR: Error writing response to browser: %+v", err)
 	}
 }
 
 // socket will handle the socket connection
 func (fs *FileServer) socket(w http.ResponseWriter, req *http.Request) {
-	mysock.ServeWS(fs.Hub, w, req)
+	ctx := req.Context()
+	mysock.ServeWS(fs.Hub, w, req, func(upath string, list bool) bool {
+		if internalPath(upath) {
+			return false
+		}
+		if list {
+			return fs.allowedCtx(ctx, upath, myacl.List)
+		}
+		return fs.allowedCtx(ctx, upath, myacl.Read) || fs.allowedCtx(ctx, upath, myacl.List)
+	})
 }
 
 // clipboardAdd will handle the add request for adding text to the clipboard
@@ -200,6 +905,55 @@ func (fs *FileServer) cbDown(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// cbImport will add the entries of a clipboard export to the clipboard.
+// The export is either posted as body or as file of the import form.
+func (fs *FileServer) cbImport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		fs.fileOpsError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		fs.fileOpsError(w, req, errCrossOrigin, http.StatusForbidden)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxClipboardImport)
+	var src io.Reader = req.Body
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := req.FormFile("file")
+		if err != nil {
+			fs.fileOpsError(w, req, fmt.Errorf("Error reading clipboard export: %+v", err), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		src = file
+	}
+
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		fs.fileOpsError(w, req, fmt.Errorf("Error reading clipboard export: %+v", err), http.StatusBadRequest)
+		return
+	}
+	count, err := fs.Clipboard.Import(content)
+	if err != nil {
+		fs.fileOpsError(w, req, err, http.StatusBadRequest)
+		return
+	}
+	log.Printf("INFO:  %s imported %d clipboard entries", requestUser(req), count)
+	fs.Hub.RefreshClipboard()
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+
+	if wantsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]int{"imported": count}); err != nil {
+			log.Printf("ERROR: Error writing response to browser: %+v", err)
+		}
+		return
+	}
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
 // static will give static content for style and function
 func (fs *FileServer) static(w http.ResponseWriter, req *http.Request) {
 	// Check which file to serve
@@ -237,8 +991,20 @@ func (fs *FileServer) handler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Do not serve unfinished uploads
+	if internalPath(upath) {
+		fs.handleError(w, req, errors.New("File not found"), http.StatusNotFound)
+		return
+	}
+
+	// The root lists the mounts if several directories are served
+	if fs.mounts.Virtual() && path.Clean(upath) == "/" {
+		fs.mountList(w, req)
+		return
+	}
+
 	// Define absolute path
-	open := fs.Webroot + path.Clean(upath)
+	open := fs.diskPath(path.Clean(upath))
 
 	// Check if you are in a dir
 	// disable G304 (CWE-22): Potential file inclusion via variable
@@ -262,82 +1028,62 @@ func (fs *FileServer) handler(w http.ResponseWriter, req *http.Request) {
 	// #nosec G307
 	defer file.Close()
 
+	// Check access control
+	stat, _ := file.Stat()
+	perm := myacl.Read
+	if stat.IsDir() {
+		perm = myacl.List
+	}
+	if !fs.allowed(req, upath, perm) {
+		fs.handleError(w, req, errors.New("You are not allowed to access this resource"), http.StatusForbidden)
+		return
+	}
+
 	// Log request
-	mylog.LogRequest(req.RemoteAddr, req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
 
 	// Switch and check if dir
-	stat, _ := file.Stat()
-	if stat.IsDir() {
-		fs.processDir(w, req, file, upath)
-	} else {
+	if !stat.IsDir() {
 		fs.sendFile(w, req, file)
+		return
+	}
+	fis, err := file.Readdir(-1)
+	if err != nil {
+		fs.handleError(w, req, err, http.StatusNotFound)
+		return
+	}
+	if wantsJSON(req) {
+		fs.processDirJSON(w, req, fis, upath)
+	} else {
+		fs.processDir(w, req, fis, upath)
 	}
 }
 
-// upload handles the POST request to upload files
-func (fs *FileServer) upload(w http.ResponseWriter, req *http.Request) {
-	// Get url so you can extract Headline and title
-	upath := req.URL.Path
-
-	// construct target path
-	targetpath := strings.Split(upath, "/")
-	targetpath = targetpath[:len(targetpath)-1]
-	target := strings.Join(targetpath, "/")
-
-	// Parse request
-	if err := req.ParseMultipartForm(10 << 20); err != nil {
-		log.Printf("Error parsing multipart request: %+v", err)
+// mountList will list the mounted directories at the root
+func (fs *FileServer) mountList(w http.ResponseWriter, req *http.Request) {
+	if !fs.allowed(req, "/", myacl.List) {
+		fs.handleError(w, req, errors.New("You are not allowed to access this resource"), http.StatusForbidden)
 		return
 	}
 
-	// Get ref to the parsed multipart form
-	m := req.MultipartForm
-
-	// Get the File Headers
-	files := m.File["files"]
-
-	for i := range files {
-		file, err := files[i].Open()
-		defer file.Close()
-		if err != nil {
-			log.Printf("Error retrieving the file: %+v\n", err)
-		}
-
-		filename := files[i].Filename
-
-		// Sanitize filename (No path traversal)
-		filenameSlice := strings.Split(filename, "/")
-		filenameClean := filenameSlice[len(filenameSlice)-1]
-
-		// Construct absolute savepath
-		savepath := fmt.Sprintf("%s%s/%s", fs.Webroot, target, filenameClean)
-
-		// Create file to write to
-		if _, err := os.Create(savepath); err != nil {
-			log.Println("ERROR: Not able to create file on disk")
-			fs.handleError(w, req, err, http.StatusInternalServerError)
-		}
-
-		// Read file from post body
-		fileBytes, err := ioutil.ReadAll(file)
-		if err != nil {
-			log.Println("ERROR: Not able to read file from request")
-			fs.handleError(w, req, err, http.StatusInternalServerError)
-		}
-
-		// Write file to disk
-		if err := ioutil.WriteFile(savepath, fileBytes, os.ModePerm); err != nil {
-			log.Println("ERROR: Not able to write file to disk")
-			fs.handleError(w, req, err, http.StatusInternalServerError)
-		}
-
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	if wantsJSON(req) {
+		fs.processDirJSON(w, req, fs.mounts.Stat(), "/")
+	} else {
+		fs.processDir(w, req, fs.mounts.Stat(), "/")
 	}
+}
 
-	// Log request
-	mylog.LogRequest(req.RemoteAddr, req.Method, req.URL.Path, req.Proto, http.StatusOK)
-
-	// Redirect back from where we came from
-	http.Redirect(w, req, target, http.StatusSeeOther)
+// servedFrom will describe the served directories for logging
+func (fs *FileServer) servedFrom() string {
+	if !fs.mounts.Virtual() {
+		return fs.Webroot
+	}
+	paths := make([]string, 0, len(fs.mounts.Mounts()))
+	for _, m := range fs.mounts.Mounts() {
+		paths = append(paths, m.Path)
+	}
+	return "mounts " + strings.Join(paths, ", ")
 }
 
 // bulkDownload will provide zip archived download bundle of multiple selected files
@@ -363,6 +1109,12 @@ func (fs *FileServer) bulkDownload(w http.ResponseWriter, req *http.Request) {
 		filesCleaned = append(filesCleaned, fileCleaned)
 	}
 
+	fs.sendZip(w, req, filesCleaned)
+}
+
+// sendZip will send the files and directories (recursively) as zip archive
+// leaving out what the user is not allowed to see
+func (fs *FileServer) sendZip(w http.ResponseWriter, req *http.Request, files []string) {
 	// Construct filename to download
 	filename := fmt.Sprintf("%+v_goshs_download.zip", int32(time.Now().Unix()))
 
@@ -377,48 +1129,34 @@ func (fs *FileServer) bulkDownload(w http.ResponseWriter, req *http.Request) {
 	resultZip := zip.NewWriter(w)
 	defer resultZip.Close()
 
-	// Path walker for recursion
-	walker := func(filepath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
+	// Path walker for recursion below the url path of a selected file
+	walker := func(file, root string) filepath.WalkFunc {
+		return func(fpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
 
-		// disable G304 (CWE-22): Potential file inclusion via variable
-		// as we want a file inclusion here
-		// #nosec G304
-		file, err := os.Open(filepath)
-		if err != nil {
-			return err
+			upath := path.Join(file, filepath.ToSlash(strings.TrimPrefix(fpath, root)))
+			return fs.zipFile(req, resultZip, fpath, upath, info)
 		}
-		// disable G307 (CWE-703): Deferring unsafe method "Close" on type "*os.File"
-		// #nosec G307
-		defer file.Close()
+	}
 
-		// filepath is fs.Webroot + file relative path
-		// this would result in a lot of nested folders
-		// so we are stripping fs.Webroot again from the structure of the zip file
-		// Leaving us with the relative path of the file
-		zippath := strings.ReplaceAll(filepath, fs.Webroot, "")
-		f, err := resultZip.Create(zippath[1:])
-		if err != nil {
-			return err
+	// Loop over files and add to zip, the mounts are zipped like directories
+	for _, file := range files {
+		file = path.Clean("/" + file)
+		if fs.mounts.Virtual() && file == "/" {
+			for _, m := range fs.mounts.Mounts() {
+				if err := filepath.Walk(m.Dir, walker(m.Path, m.Dir)); err != nil {
+					log.Printf("Error creating zip file: %+v", err)
+				}
+			}
+			continue
 		}
-
-		_, err = io.Copy(f, file)
-		if err != nil {
-			return err
+		root := fs.diskPath(file)
+		if root == "" {
+			continue
 		}
-
-		return nil
-	}
-
-	// Loop over files and add to zip
-	for _, file := range filesCleaned {
-		err := filepath.Walk(path.Join(fs.Webroot, file), walker)
-		if err != nil {
+		if err := filepath.Walk(root, walker(file, root)); err != nil {
 			log.Printf("Error creating zip file: %+v", err)
 		}
 	}
@@ -429,14 +1167,53 @@ func (fs *FileServer) bulkDownload(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file *os.File, relpath string) {
-	// Read directory FileInfo
-	fis, err := file.Readdir(-1)
+// zipFile will add the file at fpath to the zip archive as upath if the user may read it
+func (fs *FileServer) zipFile(req *http.Request, resultZip *zip.Writer, fpath, upath string, info os.FileInfo) error {
+	// Check access control, skip what the user may not see
+	if internalPath(upath) {
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if info.IsDir() {
+		if !fs.allowed(req, upath, myacl.List) {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if !fs.allowed(req, upath, myacl.Read) {
+		return nil
+	}
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as we want a file inclusion here
+	// #nosec G304
+	file, err := os.Open(fpath)
 	if err != nil {
-		fs.handleError(w, req, err, http.StatusNotFound)
-		return
+		return err
+	}
+	// disable G307 (CWE-703): Deferring unsafe method "Close" on type "*os.File"
+	// #nosec G307
+	defer file.Close()
+
+	// The zip keeps the url path, which is the mount and the path below it
+	f, err := resultZip.Create(strings.TrimPrefix(upath, "/"))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, file)
+	if err != nil {
+		return err
 	}
 
+	return nil
+}
+
+// items will turn the FileInfo of a directory into sorted items
+// leaving out what the user is not allowed to see
+func (fs *FileServer) items(req *http.Request, fis []os.FileInfo, relpath string) []item {
 	// Create empty slice
 	items := make([]item, 0, len(fis))
 	// Iterate over FileInfo of dir
@@ -445,6 +1222,18 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 		// Need to set this up here for directories to work
 		item.Name = fi.Name()
 		item.Ext = strings.ToLower(myutils.ReturnExt(fi.Name()))
+		// Hide what the user is not allowed to see
+		perm := myacl.Read
+		if fi.IsDir() {
+			perm = myacl.List
+		}
+		if !fs.allowed(req, path.Join(relpath, fi.Name()), perm) {
+			continue
+		}
+		// Hide unfinished uploads
+		if internalPath(fi.Name()) {
+			continue
+		}
 		// Add / to name if dir
 		if fi.IsDir() {
 			// Check if special path exists as dir on disk and do not add
@@ -457,6 +1246,7 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 		}
 		// Set item fields
 		item.URI = url.PathEscape(path.Join(relpath, fi.Name()))
+		item.Path = path.Join(relpath, fi.Name())
 		item.DisplaySize = myutils.ByteCountDecimal(fi.Size())
 		item.SortSize = fi.Size()
 		item.DisplayLastModified = fi.ModTime().Format("Mon Jan _2 15:04:05 2006")
@@ -464,7 +1254,8 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 		// Check and resolve symlink
 		if fi.Mode()&os.ModeSymlink != 0 {
 			item.IsSymlink = true
-			item.SymlinkTarget, err = os.Readlink(path.Join(fs.Webroot, relpath, fi.Name()))
+			var err error
+			item.SymlinkTarget, err = os.Readlink(fs.diskPath(path.Join(relpath, fi.Name())))
 			if err != nil {
 				log.Printf("Error resolving symlink: %+v", err)
 			}
@@ -478,6 +1269,13 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
 	})
 
+	return items
+}
+
+func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, fis []os.FileInfo, relpath string) {
+	// Build items for the template
+	items := fs.items(req, fis, relpath)
+
 	// Template parsing and writing to browser
 	indexFile, err := parcello.Open("templates/index.html")
 	if err != nil {
@@ -491,7 +1289,7 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 	// Construct directory for template
 	d := &directory{
 		RelPath: relpath,
-		AbsPath: path.Join(fs.Webroot, relpath),
+		AbsPath: fs.absPath(relpath),
 		Content: items,
 	}
 	if relpath != "/" {
@@ -515,9 +1313,10 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 
 	// Construct template
 	tem := &indexTemplate{
-		Directory:    d,
-		GoshsVersion: fs.Version,
-		Clipboard:    fs.Clipboard,
+		Directory:     d,
+		GoshsVersion:  fs.Version,
+		Clipboard:     fs.Clipboard,
+		NoDestructive: fs.NoDestructive,
 	}
 
 	t := template.New("index")
@@ -530,26 +1329,44 @@ func (fs *FileServer) processDir(w http.ResponseWriter, req *http.Request, file
 }
 
 func (fs *FileServer) sendFile(w http.ResponseWriter, req *http.Request, file *os.File) {
+	stat, err := file.Stat()
+	if err != nil {
+		fs.handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+
 	// Extract download parameter
 	download := req.URL.Query()
 	if _, ok := download["download"]; ok {
-		stat, err := file.Stat()
-		if err != nil {
-			log.Printf("Error reading file stats for download: %+v", err)
-		}
 		contentDisposition := fmt.Sprintf("attachment; filename=\"%s\"", stat.Name())
 		// Handle as download
-		w.Header().Add("Content-Type", "application/octet-stream")
-		w.Header().Add("Content-Disposition", contentDisposition)
-		if _, err := io.Copy(w, file); err != nil {
-			log.Printf("ERROR: Error writing response to browser: %+v", err)
-		}
-	} else {
-		// Write to browser
-		if _, err := io.Copy(w, file); err != nil {
-			log.Printf("ERROR: Error writing response to browser: %+v", err)
-		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", contentDisposition)
+	}
+
+	// ETag from modification time and size so that If-None-Match and
+	// If-Range work across restarts as long as the file is unchanged
+	w.Header().Set("ETag", etag(stat))
+
+	// ServeContent handles Range (single and multipart/byteranges),
+	// If-Modified-Since, If-None-Match, If-Range, Content-Length and Content-Type
+	http.ServeContent(w, req, stat.Name(), stat.ModTime(), file)
+}
+
+// etag will return a strong entity tag for the file
+func etag(stat os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size())
+}
+
+// absPath will return the path on disk shown in the page title, the url path if there is none
+func (fs *FileServer) absPath(upath string) string {
+	if fs.mounts == nil {
+		return path.Join(fs.Webroot, upath)
+	}
+	if disk := fs.diskPath(path.Clean(upath)); disk != "" {
+		return disk
 	}
+	return upath
 }
 
 func (fs *FileServer) handleError(w http.ResponseWriter, req *http.Request, err error, status int) {
@@ -560,12 +1377,12 @@ func (fs *FileServer) handleError(w http.ResponseWriter, req *http.Request, err
 	var e httperror
 
 	// Log to console
-	mylog.LogRequest(req.RemoteAddr, req.Method, req.URL.Path, req.Proto, status)
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, status)
 
 	// Construct error for template filling
 	e.ErrorCode = status
 	e.ErrorMessage = err.Error()
-	e.AbsPath = path.Join(fs.Webroot, req.URL.Path)
+	e.AbsPath = fs.absPath(req.URL.Path)
 	e.GoshsVersion = fs.Version
 
 	// Template handling
@@ -0,0 +1,624 @@
+package myhttp
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickhener/goshs/internal/mymount"
+	"github.com/phogolabs/parcello"
+)
+
+func TestMain(m *testing.M) {
+	// The templates are read from the source tree, tests run without a bundle
+	parcello.Manager = parcello.Dir("../../static")
+	os.Exit(m.Run())
+}
+
+// newServer will set up fs and serve it until the test ends
+func newServer(t *testing.T, fs *FileServer) *httptest.Server {
+	t.Helper()
+	if err := fs.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(fs.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		if err := fs.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return srv
+}
+
+// writeFile will write content to name below dir and return its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// do will send a request and return the response with the body read
+func do(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// A truncated body is part of some tests, what arrived is returned
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// newRequest will build a request or fail the test
+func newRequest(t *testing.T, method, url string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// fileOp will post a file operation the way the browser does
+func fileOp(t *testing.T, srv *httptest.Server, op string, form url.Values) *http.Response {
+	t.Helper()
+	req := newRequest(t, http.MethodPost, srv.URL+fileOpsPrefix+op, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Accept", "application/json")
+	resp, _ := do(t, req)
+	return resp
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func TestBasicAuth(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "a.txt", "hello")
+	srv := newServer(t, &FileServer{Webroot: root, BasicAuth: "secret"})
+
+	resp, _ := do(t, newRequest(t, http.MethodGet, srv.URL+"/a.txt", nil))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without credentials: %d", resp.StatusCode)
+	}
+
+	req := newRequest(t, http.MethodGet, srv.URL+"/a.txt", nil)
+	req.SetBasicAuth("gopher", "wrong")
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: %d", resp.StatusCode)
+	}
+
+	req = newRequest(t, http.MethodGet, srv.URL+"/a.txt", nil)
+	req.SetBasicAuth("gopher", "secret")
+	if resp, body := do(t, req); resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Errorf("with credentials: %d %q", resp.StatusCode, body)
+	}
+
+	// Share links carry their own token
+	req = newRequest(t, http.MethodGet, srv.URL+sharePrefix+"get/unknown", nil)
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("share link without credentials: %d", resp.StatusCode)
+	}
+}
+
+func TestFileOpsCrossOrigin(t *testing.T) {
+	root := t.TempDir()
+	file := writeFile(t, root, "a.txt", "hello")
+	srv := newServer(t, &FileServer{Webroot: root})
+
+	form := url.Values{"file": {"/a.txt"}}.Encode()
+	tests := map[string]string{
+		"no header":    "",
+		"other origin": "http://evil.example",
+	}
+	for name, origin := range tests {
+		req := newRequest(t, http.MethodPost, srv.URL+fileOpsPrefix+"delete", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if resp, _ := do(t, req); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: %d, want %d", name, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	if !exists(file) {
+		t.Fatal("file deleted by a cross-origin request")
+	}
+
+	// The own origin is fine
+	req := newRequest(t, http.MethodPost, srv.URL+fileOpsPrefix+"delete", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", srv.URL)
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("same origin: %d", resp.StatusCode)
+	}
+	if exists(file) {
+		t.Error("file not deleted")
+	}
+}
+
+func TestClipboardImportCrossOrigin(t *testing.T) {
+	fs := &FileServer{Webroot: t.TempDir()}
+	srv := newServer(t, fs)
+	export := `[{"ID": 7, "Content": "imported", "Time": "Mon Jan  2 15:04:05 2006"}]`
+	importURL := srv.URL + "/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/import"
+
+	req := newRequest(t, http.MethodPost, importURL, strings.NewReader(export))
+	req.Header.Set("Origin", "http://evil.example")
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin import: %d", resp.StatusCode)
+	}
+	if entries, _ := fs.Clipboard.GetEntries(); len(entries) != 0 {
+		t.Fatalf("cross-origin import changed the clipboard: %+v", entries)
+	}
+
+	req = newRequest(t, http.MethodPost, importURL, strings.NewReader(export))
+	req.Header.Set("X-Requested-With", "curl")
+	req.Header.Set("Accept", "application/json")
+	if resp, body := do(t, req); resp.StatusCode != http.StatusOK {
+		t.Errorf("import: %d %s", resp.StatusCode, body)
+	}
+	if entries, _ := fs.Clipboard.GetEntries(); len(entries) != 1 || entries[0].Content != "imported" {
+		t.Errorf("clipboard after import: %+v", entries)
+	}
+}
+
+func TestFileOpsACL(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "dir/keep/a.txt", "keep")
+	writeFile(t, root, "dir/b.txt", "b")
+	policy := writeFile(t, t.TempDir(), "policy.json", `{"rules": [
+		{"path": "/", "users": ["*"], "allow": ["read", "list", "upload", "overwrite", "delete"]},
+		{"path": "/dir/keep", "users": ["*"], "allow": ["read", "list"]}
+	]}`)
+	srv := newServer(t, &FileServer{Webroot: root, ACLFile: policy})
+
+	// A directory can not be deleted with a protected file in it
+	if resp := fileOp(t, srv, "delete", url.Values{"file": {"/dir"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("delete /dir: %d", resp.StatusCode)
+	}
+	if resp := fileOp(t, srv, "rename", url.Values{"from": {"/dir"}, "to": {"moved"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("rename /dir: %d", resp.StatusCode)
+	}
+	if resp := fileOp(t, srv, "mkdir", url.Values{"dir": {"/dir/keep"}, "name": {"new"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("mkdir in /dir/keep: %d", resp.StatusCode)
+	}
+	if !exists(filepath.Join(root, "dir", "keep", "a.txt")) || !exists(filepath.Join(root, "dir", "b.txt")) {
+		t.Fatal("files removed by a denied operation")
+	}
+
+	if resp := fileOp(t, srv, "delete", url.Values{"file": {"/dir/b.txt"}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("delete /dir/b.txt: %d", resp.StatusCode)
+	}
+	if exists(filepath.Join(root, "dir", "b.txt")) {
+		t.Error("file not deleted")
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	dir := t.TempDir()
+	src := writeFile(t, dir, "src", "new")
+	dst := writeFile(t, dir, "dst", "created meanwhile")
+
+	// A file created after the permission check is never replaced by an upload
+	if err := placeFile(src, dst, false); err != errExists {
+		t.Fatalf("placeFile = %v, want %v", err, errExists)
+	}
+	if content, _ := ioutil.ReadFile(dst); string(content) != "created meanwhile" {
+		t.Errorf("existing file replaced with %q", content)
+	}
+
+	if err := placeFile(src, filepath.Join(dir, "other"), false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(src) {
+		t.Error("source left behind")
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "other")); string(content) != "new" {
+		t.Errorf("placed file has %q", content)
+	}
+}
+
+func TestPutCreateOnly(t *testing.T) {
+	root := t.TempDir()
+	srv := newServer(t, &FileServer{Webroot: root})
+
+	// Of concurrent creates exactly one wins, the others are told the file exists
+	statuses := make(chan int, 8)
+	for i := 0; i < cap(statuses); i++ {
+		go func(i int) {
+			req, err := http.NewRequest(http.MethodPut, srv.URL+"/a.txt", strings.NewReader(strconv.Itoa(i)))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			req.Header.Set("If-None-Match", "*")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(i)
+	}
+	count := make(map[int]int)
+	for i := 0; i < cap(statuses); i++ {
+		count[<-statuses]++
+	}
+	if count[http.StatusCreated] != 1 || count[http.StatusPreconditionFailed] != cap(statuses)-1 {
+		t.Errorf("statuses %+v, want one %d and %d", count, http.StatusCreated, http.StatusPreconditionFailed)
+	}
+}
+
+func TestWebDAVProtectedSubtree(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "dir/keep/a.txt", "keep")
+	policy := writeFile(t, t.TempDir(), "policy.json", `{"rules": [
+		{"path": "/", "users": ["*"], "allow": ["read", "list", "upload", "overwrite", "delete"]},
+		{"path": "/dir/keep", "users": ["*"], "allow": ["read", "list"]}
+	]}`)
+	srv := newServer(t, &FileServer{Webroot: root, ACLFile: policy, WebDAV: true})
+
+	if resp, _ := do(t, newRequest(t, "DELETE", srv.URL+webdavPrefix+"/dir", nil)); resp.StatusCode < 400 {
+		t.Errorf("DELETE /dir: %d", resp.StatusCode)
+	}
+	req := newRequest(t, "MOVE", srv.URL+webdavPrefix+"/dir", nil)
+	req.Header.Set("Destination", srv.URL+webdavPrefix+"/moved")
+	if resp, _ := do(t, req); resp.StatusCode < 400 {
+		t.Errorf("MOVE /dir: %d", resp.StatusCode)
+	}
+	if !exists(filepath.Join(root, "dir", "keep", "a.txt")) {
+		t.Fatal("protected file removed")
+	}
+
+	req = newRequest(t, http.MethodPut, srv.URL+webdavPrefix+"/dir/keep/b.txt", strings.NewReader("b"))
+	if resp, _ := do(t, req); resp.StatusCode < 400 {
+		t.Errorf("PUT /dir/keep/b.txt: %d", resp.StatusCode)
+	}
+	req = newRequest(t, http.MethodPut, srv.URL+webdavPrefix+"/dir/b.txt", strings.NewReader("b"))
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT /dir/b.txt: %d", resp.StatusCode)
+	}
+}
+
+func TestMountModes(t *testing.T) {
+	tools, drop := t.TempDir(), t.TempDir()
+	writeFile(t, tools, "tool.sh", "echo")
+	srv := newServer(t, &FileServer{Mounts: []mymount.Mount{
+		{Path: "/tools", Dir: tools, ReadOnly: true},
+		{Path: "/drop", Dir: drop, UploadOnly: true},
+	}})
+
+	put := func(upath, content string) int {
+		resp, _ := do(t, newRequest(t, http.MethodPut, srv.URL+upath, strings.NewReader(content)))
+		return resp.StatusCode
+	}
+
+	if resp, body := do(t, newRequest(t, http.MethodGet, srv.URL+"/tools/tool.sh", nil)); resp.StatusCode != http.StatusOK || body != "echo" {
+		t.Errorf("GET /tools/tool.sh: %d %q", resp.StatusCode, body)
+	}
+	if status := put("/tools/new.sh", "x"); status != http.StatusForbidden {
+		t.Errorf("PUT into read only mount: %d", status)
+	}
+	// Only the listing of mounts is above them
+	if status := put("/new.txt", "x"); status != http.StatusNotFound {
+		t.Errorf("PUT next to the mounts: %d", status)
+	}
+	if status := put("/drop/loot.txt", "first"); status != http.StatusCreated {
+		t.Errorf("PUT into upload only mount: %d", status)
+	}
+	if status := put("/drop/loot.txt", "second"); status != http.StatusForbidden {
+		t.Errorf("overwrite in upload only mount: %d", status)
+	}
+	if resp := fileOp(t, srv, "delete", url.Values{"file": {"/drop/loot.txt"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("delete in upload only mount: %d", resp.StatusCode)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(drop, "loot.txt")); err != nil || string(content) != "first" {
+		t.Errorf("upload only file changed: %q %v", content, err)
+	}
+}
+
+func TestOperatorFilesHidden(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "goshs.yaml", "auth:\n  users:\n    alice: secret\n")
+	writeFile(t, root, "clipboard.json", "[]")
+	writeFile(t, root, "a.txt", "hello")
+	caDir := t.TempDir()
+	writeFile(t, caDir, "ca.key", "PRIVATE KEY")
+	for link, target := range map[string]string{
+		"link.yaml": filepath.Join(root, "goshs.yaml"),
+		"ca":        caDir,
+		"key.pem":   filepath.Join(caDir, "ca.key"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// The config file is given relative to the working directory
+	srv := newServer(t, &FileServer{
+		Webroot:       root,
+		ConfigFile:    "goshs.yaml",
+		ClipboardFile: filepath.Join(root, "clipboard.json"),
+		CADir:         caDir,
+		WebDAV:        true,
+	})
+
+	for _, upath := range []string{"/goshs.yaml", "/link.yaml", "/clipboard.json", "/ca/", "/ca/ca.key", "/key.pem", webdavPrefix + "/key.pem", webdavPrefix + "/ca/ca.key"} {
+		resp, body := do(t, newRequest(t, http.MethodGet, srv.URL+upath, nil))
+		if resp.StatusCode == http.StatusOK || strings.Contains(body, "secret") || strings.Contains(body, "PRIVATE") {
+			t.Errorf("GET %s: %d", upath, resp.StatusCode)
+		}
+	}
+	for _, upath := range []string{"/goshs.yaml", "/link.yaml", "/ca/new.txt"} {
+		if resp, _ := do(t, newRequest(t, http.MethodPut, srv.URL+upath, strings.NewReader("port: 1\n"))); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("PUT %s: %d", upath, resp.StatusCode)
+		}
+	}
+	if exists(filepath.Join(caDir, "new.txt")) {
+		t.Error("file uploaded into the CA directory")
+	}
+
+	req := newRequest(t, http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("Accept", "application/json")
+	resp, body := do(t, req)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "a.txt") {
+		t.Errorf("listing: %d %s", resp.StatusCode, body)
+	}
+	for _, name := range []string{"goshs.yaml", "link.yaml", "clipboard.json", "key.pem", `"ca/"`} {
+		if strings.Contains(body, name) {
+			t.Errorf("%s listed: %s", name, body)
+		}
+	}
+
+	_, body = do(t, newRequest(t, http.MethodGet, srv.URL+"/cf985bddf28fed5d5c53b069d6a6ebe601088ca6e20ec5a5a8438f8e1ffd9390/?file=/", nil))
+	archive, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if zipped := strings.Join(names, ","); zipped != "a.txt" {
+		t.Errorf("zipped %s", zipped)
+	}
+}
+
+func TestConfigUsers(t *testing.T) {
+	// A hash goshs can not check is refused instead of taken as the password
+	fs := &FileServer{Webroot: t.TempDir(), AuthUsers: map[string]string{"alice": "{SSHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="}}
+	if err := fs.Setup(); err == nil {
+		fs.Shutdown(context.Background())
+		t.Fatal("unsupported hash accepted")
+	}
+
+	srv := newServer(t, &FileServer{Webroot: t.TempDir(), AuthUsers: map[string]string{"alice": "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="}})
+	for password, want := range map[string]int{"password": http.StatusOK, "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=": http.StatusUnauthorized} {
+		req := newRequest(t, http.MethodGet, srv.URL+"/", nil)
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth("alice", password)
+		if resp, _ := do(t, req); resp.StatusCode != want {
+			t.Errorf("password %q: %d, want %d", password, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	root := t.TempDir()
+	content := strings.Repeat("x", 100)
+	writeFile(t, root, "a.txt", content)
+	srv := newServer(t, &FileServer{Webroot: root})
+
+	create := func() string {
+		req := newRequest(t, http.MethodPost, srv.URL+sharePrefix+"create", strings.NewReader(url.Values{"path": {"/a.txt"}, "downloads": {"1"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		resp, body := do(t, req)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create: %d %s", resp.StatusCode, body)
+		}
+		var link shareLink
+		if err := json.Unmarshal([]byte(body), &link); err != nil {
+			t.Fatal(err)
+		}
+		return srv.URL + sharePrefix + "get/" + link.Token
+	}
+	get := func(link, ranges string) (int, string) {
+		req := newRequest(t, http.MethodGet, link, nil)
+		if ranges != "" {
+			req.Header.Set("Range", ranges)
+		}
+		resp, body := do(t, req)
+		return resp.StatusCode, body
+	}
+
+	// Share links are created by the own origin only
+	req := newRequest(t, http.MethodPost, srv.URL+sharePrefix+"create", strings.NewReader("path=/a.txt"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin create: %d", resp.StatusCode)
+	}
+
+	link := create()
+
+	// Neither HEAD nor a cached copy use up the link
+	resp, _ := do(t, newRequest(t, http.MethodHead, link, nil))
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("HEAD: %d etag %q", resp.StatusCode, etag)
+	}
+	req = newRequest(t, http.MethodGet, link, nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := do(t, req); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional download: %d", resp.StatusCode)
+	}
+
+	if status, body := get(link, ""); status != http.StatusOK || body != content {
+		t.Fatalf("first download: %d %q", status, body)
+	}
+	if status, _ := get(link, ""); status != http.StatusGone {
+		t.Errorf("second download: %d", status)
+	}
+	// Used up links are dropped after reporting so
+	if status, _ := get(link, "bytes=50-"); status != http.StatusNotFound {
+		t.Errorf("range after the last download: %d", status)
+	}
+
+	// Ranges never starting at the first byte can not fetch more than the file
+	link = create()
+	received := 0
+	for i := 0; i < 5; i++ {
+		status, body := get(link, "bytes=10-")
+		if status != http.StatusPartialContent {
+			break
+		}
+		received += len(body)
+	}
+	if received != len(content) {
+		t.Errorf("received %d bytes with ranges, want %d", received, len(content))
+	}
+}
+
+func TestTusUpload(t *testing.T) {
+	root := t.TempDir()
+	fs := &FileServer{Webroot: root}
+	srv := newServer(t, fs)
+
+	tus := func(method, upath string, body io.Reader, headers map[string]string) *http.Response {
+		req := newRequest(t, method, srv.URL+upath, body)
+		req.Header.Set("Tus-Resumable", tusVersion)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, _ := do(t, req)
+		return resp
+	}
+	patch := func(location, offset, chunk string) *http.Response {
+		return tus(http.MethodPatch, location, strings.NewReader(chunk), map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": offset,
+		})
+	}
+
+	// Uploads are staged hidden in the webroot, nothing before the first one
+	if fs.tusStore.dir != filepath.Join(root, tusStagingDir) {
+		t.Errorf("staging area %s", fs.tusStore.dir)
+	}
+	if exists(fs.tusStore.dir) {
+		t.Error("staging area created before the first upload")
+	}
+
+	resp := tus(http.MethodPost, tusPrefix, nil, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")),
+	})
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusCreated || !strings.HasPrefix(location, tusPrefix) {
+		t.Fatalf("create: %d %q", resp.StatusCode, location)
+	}
+
+	if resp := patch(location, "0", "hello"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "5" {
+		t.Fatalf("first chunk: %d offset %s", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := patch(location, "0", "hello"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("wrong offset: %d", resp.StatusCode)
+	}
+	if resp := tus(http.MethodHead, location, nil, nil); resp.Header.Get("Upload-Offset") != "5" {
+		t.Errorf("HEAD offset %s", resp.Header.Get("Upload-Offset"))
+	}
+	// The staging area is never served
+	staged := "/" + tusStagingDir + "/" + strings.TrimPrefix(location, tusPrefix) + ".bin"
+	if resp, _ := do(t, newRequest(t, http.MethodGet, srv.URL+staged, nil)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("staged data: %d", resp.StatusCode)
+	}
+	req := newRequest(t, http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("Accept", "application/json")
+	if _, body := do(t, req); strings.Contains(body, tusStagingDir) {
+		t.Errorf("staging area listed: %s", body)
+	}
+	if resp := patch(tusPrefix+"unknown", "0", "hello"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown upload: %d", resp.StatusCode)
+	}
+	if resp := patch(location, "5", "world"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("last chunk: %d", resp.StatusCode)
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(root, "a.txt")); err != nil || string(content) != "helloworld" {
+		t.Errorf("uploaded file: %q %v", content, err)
+	}
+	if resp := tus(http.MethodHead, location, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("finished upload still staged: %d", resp.StatusCode)
+	}
+	fs.tusStore.mu.Lock()
+	defer fs.tusStore.mu.Unlock()
+	if n := len(fs.tusStore.locks); n != 0 {
+		t.Errorf("%d locks left behind", n)
+	}
+}
+
+func TestTusSweep(t *testing.T) {
+	dir := t.TempDir()
+	store := newTusStore(dir, time.Hour)
+	t.Cleanup(store.close)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"expired.json", "expired.bin", "orphan.json", "active.json", "active.bin", "creating.json"} {
+		writeFile(t, dir, name, "")
+	}
+	for _, name := range []string{"expired.json", "expired.bin", "orphan.json", "active.json"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.sweep()
+	for name, want := range map[string]bool{
+		"expired.json":  false,
+		"expired.bin":   false,
+		"orphan.json":   false,
+		"active.json":   true,
+		"active.bin":    true,
+		"creating.json": true,
+	} {
+		if got := exists(filepath.Join(dir, name)); got != want {
+			t.Errorf("%s exists %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package myhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/patrickhener/goshs/internal/mylog"
+)
+
+// wantsJSON will check if the client asked for a json listing
+// either with the Accept header or the json query parameter
+func wantsJSON(req *http.Request) bool {
+	if _, ok := req.URL.Query()["json"]; ok {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// processDirJSON will deliver the directory listing as json.
+// With ?recursive subdirectories are listed as children, ?depth=N limits
+// the recursion to N levels and ?glob=PAT only lists entries whose name
+// matches the pattern (path.Match syntax).
+func (fs *FileServer) processDirJSON(w http.ResponseWriter, req *http.Request, fis []os.FileInfo, relpath string) {
+	query := req.URL.Query()
+
+	// Recursion depth, 0 means only this directory, -1 unlimited
+	depth := 0
+	if _, ok := query["recursive"]; ok {
+		depth = -1
+	}
+	if d := query.Get("depth"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 {
+			fs.jsonError(w, req, errors.New("depth has to be a positive number"), http.StatusBadRequest)
+			return
+		}
+		depth = n
+	}
+
+	glob := query.Get("glob")
+	if _, err := path.Match(glob, ""); err != nil {
+		fs.jsonError(w, req, err, http.StatusBadRequest)
+		return
+	}
+
+	d := &directory{
+		RelPath: relpath,
+		Content: fs.jsonItems(req, fs.items(req, fis, relpath), depth, glob),
+	}
+	if d.Content == nil {
+		d.Content = []item{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(d); err != nil {
+		log.Printf("ERROR: Error writing response to browser: %+v", err)
+	}
+}
+
+// jsonItems will descend into subdirectories up to depth and apply the glob filter.
+// Directories are kept if they match the glob themselves or contain matches.
+func (fs *FileServer) jsonItems(req *http.Request, items []item, depth int, glob string) []item {
+	var result []item
+	for _, it := range items {
+		name := strings.TrimSuffix(it.Name, "/")
+		matches := glob == ""
+		if !matches {
+			matches, _ = path.Match(glob, name)
+		}
+
+		if it.IsDir && depth != 0 {
+			fis, err := ioutil.ReadDir(fs.diskPath(it.Path))
+			if err != nil {
+				log.Printf("ERROR: Unable to read directory %+v: %+v", it.Path, err)
+			} else {
+				it.Children = fs.jsonItems(req, fs.items(req, fis, it.Path), depth-1, glob)
+			}
+			if len(it.Children) > 0 {
+				matches = true
+			}
+		}
+
+		if matches {
+			result = append(result, it)
+		}
+	}
+
+	return result
+}
+
+// jsonError will log the request and answer with a json encoded error
+func (fs *FileServer) jsonError(w http.ResponseWriter, req *http.Request, err error, status int) {
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); err != nil {
+		log.Printf("ERROR: Error writing response to browser: %+v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package myhttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/patrickhener/goshs/internal/mysystemd"
+	"github.com/patrickhener/goshs/internal/myutils"
+)
+
+// ListenSystemd is the listen address taking the sockets passed by systemd socket activation
+const ListenSystemd = "systemd"
+
+// listener is a socket to serve goshs on
+type listener struct {
+	net.Listener
+	// name is used for logging
+	name string
+	tls  bool
+}
+
+// listen will open the sockets of Listeners, or IP:Port if there are none. An address is
+//
+//	host:port          https if tls is configured, http otherwise, host may be an interface name
+//	http://host:port   always http, e.g. for a trusted network
+//	https://host:port  always https, needs tls to be configured
+//	unix:/path         a unix domain socket with http for a local reverse proxy
+//	systemd            the sockets passed by systemd socket activation
+func (fs *FileServer) listen() ([]listener, error) {
+	addresses := fs.Listeners
+	if len(addresses) == 0 {
+		addresses = []string{net.JoinHostPort(fs.IP, fmt.Sprint(fs.Port))}
+	}
+
+	var listeners []listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, address := range addresses {
+		opened, err := fs.open(strings.TrimSpace(address))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, opened...)
+	}
+
+	return listeners, nil
+}
+
+// open will open the sockets of a single listen address
+func (fs *FileServer) open(address string) ([]listener, error) {
+	useTLS := fs.tlsConfig != nil
+
+	switch {
+	case address == ListenSystemd:
+		sockets, err := mysystemd.Listeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(sockets) == 0 {
+			return nil, errors.New("no sockets passed by systemd, is goshs started by a .socket unit?")
+		}
+		listeners := make([]listener, 0, len(sockets))
+		for _, l := range sockets {
+			listeners = append(listeners, listener{Listener: l, name: "systemd socket " + l.Addr().String(), tls: useTLS})
+		}
+		return listeners, nil
+	case strings.HasPrefix(address, "unix:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+		// A socket left behind by a crashed goshs would block the start
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []listener{{Listener: l, name: "unix:" + path}}, nil
+	case strings.HasPrefix(address, "http://"):
+		useTLS = false
+		address = strings.TrimPrefix(address, "http://")
+	case strings.HasPrefix(address, "https://"):
+		if fs.tlsConfig == nil {
+			return nil, fmt.Errorf("listen address %s needs tls, use -s", address)
+		}
+		useTLS = true
+		address = strings.TrimPrefix(address, "https://")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %+v", address, err)
+	}
+	// The host may be an interface name
+	host, err = myutils.ResolveHost(host)
+	if err != nil {
+		return nil, err
+	}
+	address = net.JoinHostPort(host, port)
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return []listener{{Listener: l, name: host + " port " + port, tls: useTLS}}, nil
+}
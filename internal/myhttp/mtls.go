@@ -0,0 +1,101 @@
+package myhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/patrickhener/goshs/internal/myca"
+	"github.com/patrickhener/goshs/internal/mylog"
+)
+
+// ClientCAGoshs is the value of FileServer.ClientCA to trust the self-signed goshs CA
+const ClientCAGoshs = "ca"
+
+// setupClientAuth will require client certificates signed by the client CA
+func (fs *FileServer) setupClientAuth(conf *tls.Config) error {
+	if conf == nil {
+		return errors.New("client certificates need TLS, use -s")
+	}
+
+	var pool *x509.CertPool
+	if fs.ClientCA == ClientCAGoshs {
+		// An unsaved CA is gone after the start so nobody could have a certificate
+		if fs.CA == nil || fs.CADir == "" {
+			return errors.New("client certificates from the goshs CA need a persistent CA, use -sca")
+		}
+		pool = x509.NewCertPool()
+		pool.AddCert(fs.CA.Cert)
+	} else {
+		var err error
+		pool, err = myca.CertPool(fs.ClientCA)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch fs.ClientCertUser {
+	case "", "cn", "email", "dns":
+	default:
+		return fmt.Errorf("unknown client certificate user field %s, use cn, email or dns", fs.ClientCertUser)
+	}
+
+	conf.ClientCAs = pool
+	// Share links, the CA download and TLS-ALPN-01 challenges come without client
+	// certificate, the middleware rejects every other request without one
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return nil
+}
+
+// ClientCertMiddleware is a middleware taking the user from the verified client certificate
+func (fs *FileServer) ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			mylog.LogRequest(r.RemoteAddr, "-", r.Method, r.URL.Path, r.Proto, http.StatusForbidden)
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		username := certUser(cert, fs.ClientCertUser)
+		if username == "" {
+			log.Printf("ERROR: Client certificate %+v has no %+v to take the user from", cert.Subject, fs.ClientCertUser)
+			mylog.LogRequest(r.RemoteAddr, "-", r.Method, r.URL.Path, r.Proto, http.StatusForbidden)
+			http.Error(w, "Client certificate without user", http.StatusForbidden)
+			return
+		}
+
+		// Remember the user for logging and access control
+		ctx := context.WithValue(r.Context(), userKey, username)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// certUser will return the username from the common name (cn),
+// the first email address (email) or the first dns name (dns) of cert
+func certUser(cert *x509.Certificate, field string) string {
+	switch strings.ToLower(field) {
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
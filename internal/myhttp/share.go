@@ -0,0 +1,332 @@
+package myhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/mylog"
+	"github.com/patrickhener/goshs/internal/myshare"
+)
+
+// sharePrefix is where share links are managed and served.
+// Everything below get/ is reachable without basic auth.
+const sharePrefix = "/c3bc45ac352fe43ff8f0a1cc26d6cc29f71f536dc417906f8513ea44ed4bb161/"
+
+// shareLink is a share link as the api hands it out
+type shareLink struct {
+	myshare.Link
+	URL string `json:"url"`
+}
+
+// share handles creating, listing, revoking and using share links
+func (fs *FileServer) share(w http.ResponseWriter, req *http.Request) {
+	op := strings.TrimPrefix(req.URL.Path, sharePrefix)
+	if strings.HasPrefix(op, "get/") {
+		fs.shareGet(w, req, strings.TrimPrefix(op, "get/"))
+		return
+	}
+
+	switch op {
+	case "create":
+		fs.shareCreate(w, req)
+	case "list":
+		fs.shareList(w, req)
+	case "revoke":
+		fs.shareRevoke(w, req)
+	default:
+		fs.jsonError(w, req, errors.New("unknown share operation"), http.StatusNotFound)
+	}
+}
+
+// shareCreate will create a link for a file or directory the user may read.
+// Form values are path, expires (duration like 24h), downloads and password.
+func (fs *FileServer) shareCreate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		fs.jsonError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		fs.jsonError(w, req, errCrossOrigin, http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		fs.jsonError(w, req, err, http.StatusBadRequest)
+		return
+	}
+
+	upath := path.Clean("/" + req.PostFormValue("path"))
+	stat, err := os.Stat(fs.diskPath(upath))
+	if err != nil || internalPath(upath) {
+		fs.jsonError(w, req, fmt.Errorf("%s does not exist", upath), http.StatusNotFound)
+		return
+	}
+	perm := myacl.Read
+	if stat.IsDir() {
+		perm = myacl.List
+	}
+	if !fs.allowed(req, upath, perm) {
+		fs.jsonError(w, req, fmt.Errorf("You are not allowed to share %s", upath), http.StatusForbidden)
+		return
+	}
+
+	var expiry time.Duration
+	if e := req.PostFormValue("expires"); e != "" {
+		expiry, err = time.ParseDuration(e)
+		if err != nil {
+			fs.jsonError(w, req, fmt.Errorf("invalid expiry: %+v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	var downloads int
+	if d := req.PostFormValue("downloads"); d != "" {
+		downloads, err = strconv.Atoi(d)
+		if err != nil {
+			fs.jsonError(w, req, fmt.Errorf("invalid downloads: %+v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	link, err := fs.Shares.Create(upath, stat.IsDir(), requestUser(req), expiry, downloads, req.PostFormValue("password"))
+	if err != nil {
+		fs.jsonError(w, req, err, http.StatusBadRequest)
+		return
+	}
+	log.Printf("INFO:  %s shared %s", requestUser(req), upath)
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusCreated)
+	fs.shareJSON(w, http.StatusCreated, &shareLink{Link: link, URL: shareURL(req, link.Token)})
+}
+
+// shareList will list the links of the user
+func (fs *FileServer) shareList(w http.ResponseWriter, req *http.Request) {
+	var links []shareLink
+	for _, link := range fs.Shares.List(requestUser(req)) {
+		links = append(links, shareLink{Link: link, URL: shareURL(req, link.Token)})
+	}
+	if links == nil {
+		links = []shareLink{}
+	}
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	fs.shareJSON(w, http.StatusOK, links)
+}
+
+// shareRevoke will remove a link of the user
+func (fs *FileServer) shareRevoke(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		fs.jsonError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(req) {
+		fs.jsonError(w, req, errCrossOrigin, http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		fs.jsonError(w, req, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := fs.Shares.Revoke(req.PostFormValue("token"), requestUser(req)); err != nil {
+		fs.jsonError(w, req, err, http.StatusNotFound)
+		return
+	}
+
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	fs.shareJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// shareGet will deliver the shared file, or the shared directory as zip archive.
+// The request acts on behalf of the creator so the access control lists still apply.
+func (fs *FileServer) shareGet(w http.ResponseWriter, req *http.Request, token string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		fs.handleError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	token = strings.SplitN(token, "/", 2)[0]
+	link, err := fs.Shares.Get(token)
+	if err == myshare.ErrExpired {
+		fs.handleError(w, req, err, http.StatusGone)
+		return
+	}
+	if err != nil {
+		fs.handleError(w, req, err, http.StatusNotFound)
+		return
+	}
+
+	if link.HasPassword {
+		_, password, ok := req.BasicAuth()
+		if !ok || !link.CheckPassword(password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Shared link"`)
+			mylog.LogRequest(req.RemoteAddr, "-", req.Method, req.URL.Path, req.Proto, http.StatusUnauthorized)
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if link.Creator != "-" {
+		req = req.WithContext(context.WithValue(req.Context(), userKey, link.Creator))
+	}
+
+	if link.IsDir {
+		if !fs.allowed(req, link.Path, myacl.List) {
+			fs.handleError(w, req, errors.New("The shared directory is not available anymore"), http.StatusForbidden)
+			return
+		}
+		// Link previews ask with HEAD first, that is no download
+		if req.Method == http.MethodHead {
+			if _, err := fs.Shares.Check(token, 0, false); err != nil {
+				fs.handleError(w, req, err, http.StatusGone)
+				return
+			}
+			mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+			w.Header().Set("Content-Type", "application/zip")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if _, err := fs.Shares.Use(token, 0); err != nil {
+			fs.handleError(w, req, err, http.StatusGone)
+			return
+		}
+		log.Printf("INFO:  %s downloaded shared directory %s", req.RemoteAddr, link.Path)
+		mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+		fs.sendZip(w, req, []string{link.Path})
+		return
+	}
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the path is taken from the share link
+	// #nosec G304
+	file, err := os.Open(fs.diskPath(link.Path))
+	if err != nil || !fs.allowed(req, link.Path, myacl.Read) {
+		fs.handleError(w, req, errors.New("The shared file is not available anymore"), http.StatusNotFound)
+		return
+	}
+	// disable G307 (CWE-703): Deferring unsafe method "Close" on type "*os.File"
+	// #nosec G307
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		fs.handleError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+
+	// A download from the first byte counts as a new one, ranges further in can only
+	// resume downloads as the bytes sent are limited to the allowed downloads as well
+	resume := rangeStart(req.Header.Get("Range")) != 0
+	if _, err := fs.Shares.Check(token, stat.Size(), resume); err != nil {
+		fs.handleError(w, req, err, http.StatusGone)
+		return
+	}
+
+	// HEAD and answers to conditional requests send no body and are not counted
+	sw := &shareWriter{ResponseWriter: w, shares: fs.Shares, token: token, size: stat.Size(), resume: resume}
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(link.Path)))
+	fs.sendFile(sw, req, file)
+	if !sw.counted && req.Method == http.MethodGet && (sw.status == http.StatusOK || sw.status == http.StatusPartialContent) {
+		// An empty file is downloaded without writing a byte
+		// disable G104 (CWE-703): Errors unhandled
+		// as nothing is left to send
+		// #nosec G104
+		sw.Write(nil)
+	}
+	if sw.counted && sw.err == nil && !resume {
+		log.Printf("INFO:  %s downloaded shared file %s", req.RemoteAddr, link.Path)
+	}
+}
+
+// rangeStart will return the first byte of the first range of a Range header,
+// 0 without header and -1 for suffix or invalid ranges
+func rangeStart(header string) int64 {
+	if header == "" {
+		return 0
+	}
+	if !strings.HasPrefix(header, "bytes=") {
+		return -1
+	}
+	spec := strings.TrimSpace(strings.SplitN(strings.TrimPrefix(header, "bytes="), ",", 2)[0])
+	start, err := strconv.ParseInt(strings.SplitN(spec, "-", 2)[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// shareWriter will count the download with the first byte of a 200 or 206
+// response and stop sending a shared file once its downloads are used up
+type shareWriter struct {
+	http.ResponseWriter
+	shares  *myshare.Store
+	token   string
+	size    int64
+	resume  bool
+	status  int
+	counted bool
+	err     error
+}
+
+// WriteHeader will remember the status to decide if the body is a download
+func (sw *shareWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *shareWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	if sw.status != http.StatusOK && sw.status != http.StatusPartialContent {
+		return sw.ResponseWriter.Write(p)
+	}
+	if !sw.counted {
+		sw.counted = true
+		if sw.resume {
+			_, sw.err = sw.shares.Resume(sw.token, sw.size)
+		} else {
+			_, sw.err = sw.shares.Use(sw.token, sw.size)
+		}
+	}
+	if sw.err != nil {
+		// Another request used up the link meanwhile
+		return 0, sw.err
+	}
+
+	n := sw.shares.Send(sw.token, int64(len(p)), sw.size)
+	written, err := sw.ResponseWriter.Write(p[:n])
+	if err == nil && n < int64(len(p)) {
+		err = myshare.ErrExpired
+	}
+	return written, err
+}
+
+// shareJSON will write v as json
+func (fs *FileServer) shareJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: Error writing response to browser: %+v", err)
+	}
+}
+
+// shareURL will return the full url of a share link as seen by the client
+func shareURL(req *http.Request, token string) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%sget/%s", scheme, req.Host, sharePrefix, token)
+}
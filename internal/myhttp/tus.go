@@ -0,0 +1,656 @@
+package myhttp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+
+	// disable G505 (CWE-327): Blocklisted import crypto/sha1: weak cryptographic primitive
+	// sha1 is the checksum algorithm every tus client has to support
+	// #nosec G505
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/mylog"
+	"github.com/patrickhener/goshs/internal/mymount"
+)
+
+// Resumable uploads following the tus protocol 1.0 with the
+// creation, termination and checksum extensions
+// https://tus.io/protocols/resumable-upload.html
+
+const (
+	tusPrefix        = "/d313bc369f912516df28487e11a73e30922a1f82d6eceaf1d58c46da5dfcf358/"
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,termination,checksum"
+	tusChecksums     = "sha1,md5,sha256"
+	tusStatusBadHash = 460
+
+	// tusStagingDir is the hidden directory the partial uploads are kept in
+	tusStagingDir = uploadTempPrefix + "tus"
+)
+
+// tusUpload holds the information about a single upload in the staging area
+type tusUpload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Dir      string            `json:"dir"`
+	Filename string            `json:"filename"`
+	Metadata map[string]string `json:"metadata"`
+	User     string            `json:"user"`
+	Created  time.Time         `json:"created"`
+}
+
+// tusLock serializes the requests of a single upload, it is dropped
+// when no request holds or waits for it anymore
+type tusLock struct {
+	sync.Mutex
+	refs int
+}
+
+// tusStore manages the partial uploads in the staging area
+type tusStore struct {
+	dir    string
+	expiry time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*tusLock
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newTusStore will start the garbage collection of the staging area in dir.
+// The directory is only created with the first upload.
+func newTusStore(dir string, expiry time.Duration) *tusStore {
+	t := &tusStore{
+		dir:    dir,
+		expiry: expiry,
+		locks:  make(map[string]*tusLock),
+		stop:   make(chan struct{}),
+	}
+
+	if expiry > 0 {
+		go t.collect()
+	}
+
+	return t
+}
+
+// defaultTusDir will return the staging area used if none is given. It is hidden
+// in the webroot, or in the first mount which is not read only, so every webroot has its own.
+func defaultTusDir(mounts *mymount.Table) string {
+	all := mounts.Mounts()
+	dir := all[0].Dir
+	for _, m := range all {
+		if !m.ReadOnly {
+			dir = m.Dir
+			break
+		}
+	}
+	return filepath.Join(dir, tusStagingDir)
+}
+
+// close will stop the garbage collection
+func (t *tusStore) close() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+// lock will lock a single upload so that chunks can not be written concurrently
+func (t *tusStore) lock(id string) func() {
+	t.mu.Lock()
+	l, ok := t.locks[id]
+	if !ok {
+		l = &tusLock{}
+		t.locks[id] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(t.locks, id)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *tusStore) dataPath(id string) string {
+	return filepath.Join(t.dir, id+".bin")
+}
+
+func (t *tusStore) infoPath(id string) string {
+	return filepath.Join(t.dir, id+".json")
+}
+
+// create will store a new empty upload
+func (t *tusStore) create(u *tusUpload) error {
+	info, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(t.infoPath(u.ID), info, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.dataPath(u.ID), nil, 0600)
+}
+
+// get will load an upload and its current offset
+func (t *tusStore) get(id string) (*tusUpload, int64, error) {
+	// Only accept what newTusID generates
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return nil, 0, os.ErrNotExist
+	}
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as the id has been validated above
+	// #nosec G304
+	content, err := ioutil.ReadFile(t.infoPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	u := &tusUpload{}
+	if err := json.Unmarshal(content, u); err != nil {
+		return nil, 0, err
+	}
+	stat, err := os.Stat(t.dataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return u, stat.Size(), nil
+}
+
+// remove will delete an upload from the staging area
+func (t *tusStore) remove(id string) {
+	for _, file := range []string{t.dataPath(id), t.infoPath(id)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("ERROR: Unable to remove tus upload file %+v: %+v", file, err)
+		}
+	}
+}
+
+// collect will periodically remove uploads which have not been touched within the expiry
+func (t *tusStore) collect() {
+	interval := t.expiry / 2
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	// Tiny expiries would let the collection spin
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+		t.sweep()
+	}
+}
+
+// sweep will remove the uploads and orphaned files which have expired
+func (t *tusStore) sweep() {
+	infos, err := ioutil.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		// Nothing has been uploaded yet
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to read tus staging area: %+v", err)
+		return
+	}
+	for _, info := range infos {
+		if time.Since(info.ModTime()) < t.expiry {
+			continue
+		}
+		switch ext := filepath.Ext(info.Name()); ext {
+		case ".bin":
+			id := strings.TrimSuffix(info.Name(), ext)
+			unlock := t.lock(id)
+			// A chunk may have arrived while waiting for the lock
+			if stat, err := os.Stat(t.dataPath(id)); err == nil && time.Since(stat.ModTime()) >= t.expiry {
+				log.Printf("INFO:  Removing expired tus upload %+v", id)
+				t.remove(id)
+			}
+			unlock()
+		case ".json":
+			// The data of an upload interrupted while it was created or removed
+			id := strings.TrimSuffix(info.Name(), ext)
+			unlock := t.lock(id)
+			if _, err := os.Stat(t.dataPath(id)); os.IsNotExist(err) {
+				log.Printf("INFO:  Removing orphaned tus upload %+v", id)
+				t.remove(id)
+			}
+			unlock()
+		}
+	}
+}
+
+// tus handles the resumable upload endpoint
+func (fs *FileServer) tus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	// OPTIONS is the only request allowed without Tus-Resumable
+	if req.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+		if fs.MaxUpload > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(fs.MaxUpload, 10))
+		}
+		fs.tusStatus(w, req, http.StatusNoContent)
+		return
+	}
+
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		fs.tusError(w, req, errors.New("unsupported tus version"), http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, tusPrefix), "/")
+	switch {
+	case id == "" && req.Method == http.MethodPost:
+		fs.tusCreate(w, req)
+	case id != "" && req.Method == http.MethodHead:
+		fs.tusHead(w, req, id)
+	case id != "" && req.Method == http.MethodPatch:
+		fs.tusPatch(w, req, id)
+	case id != "" && req.Method == http.MethodDelete:
+		fs.tusDelete(w, req, id)
+	default:
+		fs.tusError(w, req, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// tusCreate handles the creation extension
+func (fs *FileServer) tusCreate(w http.ResponseWriter, req *http.Request) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		fs.tusError(w, req, errors.New("invalid Upload-Length"), http.StatusBadRequest)
+		return
+	}
+	if fs.MaxUpload > 0 && length > fs.MaxUpload {
+		fs.tusError(w, req, errTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		fs.tusError(w, req, err, http.StatusBadRequest)
+		return
+	}
+
+	// The target is given by the metadata keys filename and dir
+	filename, err := sanitizeFilename(metadata["filename"])
+	if err != nil {
+		fs.tusError(w, req, err, http.StatusBadRequest)
+		return
+	}
+	dir := path.Clean("/" + metadata["dir"])
+	if internalPath(dir) {
+		fs.tusError(w, req, fmt.Errorf("directory %s does not exist", dir), http.StatusNotFound)
+		return
+	}
+	stat, err := os.Stat(fs.diskPath(dir))
+	if err != nil || !stat.IsDir() {
+		fs.tusError(w, req, fmt.Errorf("directory %s does not exist", dir), http.StatusNotFound)
+		return
+	}
+	if !fs.allowed(req, path.Join(dir, filename), myacl.Upload) {
+		fs.tusError(w, req, fmt.Errorf("You are not allowed to upload %s", filename), http.StatusForbidden)
+		return
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		fs.tusError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	user, _ := req.Context().Value(userKey).(string)
+	u := &tusUpload{
+		ID:       id,
+		Length:   length,
+		Dir:      dir,
+		Filename: filename,
+		Metadata: metadata,
+		User:     user,
+		Created:  time.Now(),
+	}
+	if err := fs.tusStore.create(u); err != nil {
+		log.Printf("ERROR: Unable to create tus upload in %+v: %+v", fs.tusStore.dir, err)
+		fs.tusError(w, req, errors.New("resumable uploads are not available"), http.StatusInternalServerError)
+		return
+	}
+
+	// Empty files are complete right away
+	if length == 0 {
+		if status, err := fs.tusFinish(req, u); err != nil {
+			fs.tusError(w, req, err, status)
+			return
+		}
+	}
+
+	w.Header().Set("Location", tusPrefix+id)
+	fs.tusStatus(w, req, http.StatusCreated)
+}
+
+// tusHead will report the current offset of an upload
+func (fs *FileServer) tusHead(w http.ResponseWriter, req *http.Request, id string) {
+	u, offset, status, err := fs.tusLookup(req, id)
+	if err != nil {
+		fs.tusError(w, req, err, status)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	fs.tusStatus(w, req, http.StatusOK)
+}
+
+// tusPatch will append a chunk to an upload
+func (fs *FileServer) tusPatch(w http.ResponseWriter, req *http.Request, id string) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		fs.tusError(w, req, errors.New("invalid Content-Type"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Only lock uploads which exist and belong to the user
+	if _, _, status, err := fs.tusLookup(req, id); err != nil {
+		fs.tusError(w, req, err, status)
+		return
+	}
+	unlock := fs.tusStore.lock(id)
+	defer unlock()
+
+	// Another request may have written or removed it meanwhile
+	u, offset, status, err := fs.tusLookup(req, id)
+	if err != nil {
+		fs.tusError(w, req, err, status)
+		return
+	}
+
+	requested, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requested != offset {
+		fs.tusError(w, req, errors.New("Upload-Offset does not match"), http.StatusConflict)
+		return
+	}
+
+	// Checksum extension
+	var sum hash.Hash
+	var expected []byte
+	if header := req.Header.Get("Upload-Checksum"); header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 {
+			fs.tusError(w, req, errors.New("invalid Upload-Checksum"), http.StatusBadRequest)
+			return
+		}
+		switch parts[0] {
+		case "sha1":
+			// disable G401 (CWE-326): Use of weak cryptographic primitive
+			// #nosec G401
+			sum = sha1.New()
+		case "md5":
+			// disable G401 (CWE-326): Use of weak cryptographic primitive
+			// #nosec G401
+			sum = md5.New()
+		case "sha256":
+			sum = sha256.New()
+		default:
+			fs.tusError(w, req, errors.New("unsupported checksum algorithm"), http.StatusBadRequest)
+			return
+		}
+		expected, err = base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			fs.tusError(w, req, errors.New("invalid Upload-Checksum"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	file, err := os.OpenFile(fs.tusStore.dataPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fs.tusError(w, req, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Never accept more than announced on creation
+	var src io.Reader = io.LimitReader(req.Body, u.Length-offset)
+	if sum != nil {
+		src = io.TeeReader(src, sum)
+	}
+	written, copyErr := io.Copy(file, src)
+
+	// A chunk with checksum is only kept if it is complete and matches
+	mismatch := false
+	if sum != nil && (copyErr != nil || string(sum.Sum(nil)) != string(expected)) {
+		mismatch = copyErr == nil
+		if err := file.Truncate(offset); err != nil {
+			log.Printf("ERROR: Unable to discard tus chunk: %+v", err)
+		}
+		written = 0
+	}
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if mismatch {
+		fs.tusError(w, req, errors.New("checksum mismatch"), tusStatusBadHash)
+		return
+	}
+	// An interrupted chunk is kept, the client resumes from the new offset
+	if copyErr != nil {
+		log.Printf("ERROR: tus upload %+v interrupted at offset %+v: %+v", id, offset+written, copyErr)
+	}
+
+	offset += written
+	if offset == u.Length {
+		if status, err := fs.tusFinish(req, u); err != nil {
+			fs.tusError(w, req, err, status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	fs.tusStatus(w, req, http.StatusNoContent)
+}
+
+// tusDelete handles the termination extension
+func (fs *FileServer) tusDelete(w http.ResponseWriter, req *http.Request, id string) {
+	if _, _, status, err := fs.tusLookup(req, id); err != nil {
+		fs.tusError(w, req, err, status)
+		return
+	}
+	unlock := fs.tusStore.lock(id)
+	defer unlock()
+
+	if _, _, status, err := fs.tusLookup(req, id); err != nil {
+		fs.tusError(w, req, err, status)
+		return
+	}
+
+	fs.tusStore.remove(id)
+	fs.tusStatus(w, req, http.StatusNoContent)
+}
+
+// tusLookup will load an upload and make sure it belongs to the request user
+func (fs *FileServer) tusLookup(req *http.Request, id string) (*tusUpload, int64, int, error) {
+	u, offset, err := fs.tusStore.get(id)
+	if err != nil {
+		return nil, 0, http.StatusNotFound, errors.New("upload not found")
+	}
+	user, _ := req.Context().Value(userKey).(string)
+	if u.User != user {
+		return nil, 0, http.StatusNotFound, errors.New("upload not found")
+	}
+	return u, offset, http.StatusOK, nil
+}
+
+// tusFinish will move a complete upload to its target
+func (fs *FileServer) tusFinish(req *http.Request, u *tusUpload) (int, error) {
+	dirpath := fs.diskPath(u.Dir)
+	if dirpath == "" {
+		return http.StatusNotFound, fmt.Errorf("directory %s does not exist", u.Dir)
+	}
+	savepath := filepath.Join(dirpath, u.Filename)
+
+	perm := myacl.Upload
+	if stat, err := os.Stat(savepath); err == nil {
+		if stat.IsDir() {
+			return http.StatusConflict, fmt.Errorf("%s is a directory", u.Filename)
+		}
+		perm = myacl.Overwrite
+	}
+	if !fs.allowed(req, path.Join(u.Dir, u.Filename), perm) {
+		return http.StatusForbidden, fmt.Errorf("You are not allowed to upload %s", u.Filename)
+	}
+
+	// disable G302 (CWE-276): Expect file permissions to be 0600 or less
+	// as uploaded files are meant to be shared
+	// #nosec G302
+	if err := os.Chmod(fs.tusStore.dataPath(u.ID), 0644); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	// Never replace a file created after the permission check
+	err := moveFile(fs.tusStore.dataPath(u.ID), savepath, perm == myacl.Overwrite)
+	if err == errExists {
+		return http.StatusConflict, fmt.Errorf("%s has been created meanwhile", u.Filename)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Not able to move file into place: %+v", err)
+	}
+	fs.tusStore.remove(u.ID)
+
+	log.Printf("INFO:  tus upload %+v finished as %+v", u.ID, path.Join(u.Dir, u.Filename))
+	return http.StatusOK, nil
+}
+
+// moveFile will place src at dst like placeFile. Mounts may be on another file system than the
+// staging area, then the file is copied next to dst first so dst is never partial.
+func moveFile(src, dst string, overwrite bool) error {
+	err := placeFile(src, dst, overwrite)
+	if !crossDevice(err) {
+		return err
+	}
+
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as src is in the staging area
+	// #nosec G304
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), uploadTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Only left over if something went wrong
+		// disable G104 (CWE-703): Errors unhandled
+		// #nosec G104
+		os.Remove(tmp.Name())
+	}()
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// disable G302 (CWE-276): Expect file permissions to be 0600 or less
+	// as uploaded files are meant to be shared
+	// #nosec G302
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := placeFile(tmp.Name(), dst, overwrite); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// crossDevice will check if err has been caused by a rename or link to another file system
+func crossDevice(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV
+}
+
+// tusStatus will log the request and write the status without body
+func (fs *FileServer) tusStatus(w http.ResponseWriter, req *http.Request, status int) {
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, status)
+	w.WriteHeader(status)
+}
+
+// tusError will log the request and write a plain text error as tus clients expect
+func (fs *FileServer) tusError(w http.ResponseWriter, req *http.Request, err error, status int) {
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, status)
+	http.Error(w, err.Error(), status)
+}
+
+// parseTusMetadata will decode the Upload-Metadata header
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if kv[0] == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		value := ""
+		if len(kv) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %s", kv[0])
+			}
+			value = string(decoded)
+		}
+		metadata[kv[0]] = value
+	}
+
+	return metadata, nil
+}
+
+// newTusID will return a random upload id
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,305 @@
+package myhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/mylog"
+)
+
+// uploadTempPrefix is the prefix of files which are still being uploaded
+const uploadTempPrefix = ".goshs-upload-"
+
+// errTooLarge is returned when an upload exceeds the maximum upload size
+var errTooLarge = errors.New("maximum upload size exceeded")
+
+// errExists is returned when a new file is placed but the name was taken meanwhile
+var errExists = errors.New("file already exists")
+
+// upload handles the POST request to upload files
+func (fs *FileServer) upload(w http.ResponseWriter, req *http.Request) {
+	// Get url so you can extract Headline and title
+	upath := req.URL.Path
+
+	// construct target path
+	targetpath := strings.Split(upath, "/")
+	targetpath = targetpath[:len(targetpath)-1]
+	target := strings.Join(targetpath, "/")
+
+	// Everything but a browser form is a raw upload to the path itself
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		fs.put(w, req)
+		return
+	}
+
+	// Stream the multipart body part by part instead of parsing it into memory
+	reader, err := req.MultipartReader()
+	if err != nil {
+		fs.handleError(w, req, fmt.Errorf("Error parsing multipart request: %+v", err), http.StatusBadRequest)
+		return
+	}
+
+	var failed []string
+	status := http.StatusOK
+	remaining := int64(-1)
+	if fs.MaxUpload > 0 {
+		remaining = fs.MaxUpload
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The body is broken, nothing more can be read
+			failed = append(failed, fmt.Sprintf("reading request: %+v", err))
+			status = http.StatusBadRequest
+			break
+		}
+
+		// Only handle the files of the upload form
+		if part.FormName() != "files" || part.FileName() == "" {
+			continue
+		}
+
+		filename := part.FileName()
+		written, code, err := fs.saveFile(req, target, filename, part, remaining, false)
+		if err != nil {
+			log.Printf("ERROR: Not able to upload %+v: %+v", filename, err)
+			failed = append(failed, fmt.Sprintf("%s: %+v", filename, err))
+			status = code
+			if err == errTooLarge {
+				// Do not read any further
+				break
+			}
+			continue
+		}
+		if remaining >= 0 {
+			remaining -= written
+		}
+	}
+
+	if len(failed) > 0 {
+		fs.handleError(w, req, fmt.Errorf("Upload failed for %s", strings.Join(failed, ", ")), status)
+		return
+	}
+
+	// Log request
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, http.StatusOK)
+
+	// Redirect back from where we came from
+	http.Redirect(w, req, target, http.StatusSeeOther)
+}
+
+// put handles PUT and raw body POST requests which create the file at the request path.
+// This is what curl -T, wget --method=PUT or Invoke-WebRequest -InFile do.
+func (fs *FileServer) put(w http.ResponseWriter, req *http.Request) {
+	upath := req.URL.Path
+	if strings.HasSuffix(upath, "/") {
+		fs.putStatus(w, req, http.StatusBadRequest, "A filename is needed, like /dir/filename")
+		return
+	}
+	dir, name := path.Split(upath)
+
+	// Overwrite protection
+	createOnly := req.Header.Get("If-None-Match") == "*"
+
+	limit := int64(-1)
+	if fs.MaxUpload > 0 {
+		limit = fs.MaxUpload
+	}
+	_, status, err := fs.saveFile(req, dir, name, req.Body, limit, createOnly)
+	if err == errExists && createOnly {
+		fs.putStatus(w, req, http.StatusPreconditionFailed, fmt.Sprintf("%s already exists", upath))
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Not able to upload %+v: %+v", upath, err)
+		fs.putStatus(w, req, status, err.Error())
+		return
+	}
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", upath)
+		fs.putStatus(w, req, http.StatusCreated, fmt.Sprintf("Created %s", upath))
+		return
+	}
+	fs.putStatus(w, req, http.StatusNoContent, "")
+}
+
+// putStatus will log the request and answer with a plain text message for command line clients
+func (fs *FileServer) putStatus(w http.ResponseWriter, req *http.Request, status int, message string) {
+	mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, status)
+	if message == "" {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := fmt.Fprintln(w, message); err != nil {
+		log.Printf("ERROR: Error writing response to browser: %+v", err)
+	}
+}
+
+// saveFile will stream src to dir/name below the webroot. The content is written to a
+// temporary file next to the target first and renamed once it is complete, so that
+// nobody ever sees a partial file. A limit >= 0 caps the amount of bytes accepted.
+// With createOnly an existing file is never replaced and errExists returned.
+// It returns the amount of bytes written and a http status, which is
+// http.StatusCreated or http.StatusOK (overwritten) on success.
+func (fs *FileServer) saveFile(req *http.Request, dir, name string, src io.Reader, limit int64, createOnly bool) (int64, int, error) {
+	filename, err := sanitizeFilename(name)
+	if err != nil {
+		return 0, http.StatusBadRequest, err
+	}
+
+	// Construct absolute savepath
+	dirpath := fs.diskPath(path.Clean("/" + dir))
+	savepath := filepath.Join(dirpath, filename)
+
+	// Target directory has to exist
+	stat, err := os.Stat(dirpath)
+	if err != nil || !stat.IsDir() || internalPath(dir) {
+		return 0, http.StatusNotFound, fmt.Errorf("directory %s does not exist", dir)
+	}
+
+	// Check access control
+	status := http.StatusCreated
+	perm := myacl.Upload
+	if stat, err := os.Stat(savepath); err == nil {
+		if stat.IsDir() {
+			return 0, http.StatusConflict, fmt.Errorf("%s is a directory", filename)
+		}
+		if createOnly {
+			return 0, http.StatusConflict, errExists
+		}
+		status = http.StatusOK
+		perm = myacl.Overwrite
+	}
+	if !fs.allowed(req, path.Join("/", dir, filename), perm) {
+		return 0, http.StatusForbidden, fmt.Errorf("You are not allowed to upload %s", filename)
+	}
+
+	// Create temporary file in the target dir so that the rename is atomic
+	tmp, err := ioutil.TempFile(dirpath, uploadTempPrefix+"*")
+	if err != nil {
+		return 0, http.StatusInternalServerError, errors.New("Not able to create file on disk")
+	}
+	defer func() {
+		// Only left over if something went wrong
+		// disable G104 (CWE-703): Errors unhandled
+		// #nosec G104
+		os.Remove(tmp.Name())
+	}()
+
+	var written int64
+	if limit >= 0 {
+		written, err = io.CopyN(tmp, src, limit+1)
+		if err == io.EOF {
+			err = nil
+		}
+		if err == nil && written > limit {
+			err = errTooLarge
+		}
+	} else {
+		written, err = io.Copy(tmp, src)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == errTooLarge {
+		return written, http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		return written, http.StatusInternalServerError, fmt.Errorf("Not able to write file to disk: %+v", err)
+	}
+
+	// disable G302 (CWE-276): Expect file permissions to be 0600 or less
+	// as uploaded files are meant to be shared
+	// #nosec G302
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return written, http.StatusInternalServerError, err
+	}
+	// Only an overwrite may replace a file, one created meanwhile is kept
+	if err := placeFile(tmp.Name(), savepath, status == http.StatusOK); err == errExists {
+		return written, http.StatusConflict, err
+	} else if err != nil {
+		return written, http.StatusInternalServerError, fmt.Errorf("Not able to move file into place: %+v", err)
+	}
+
+	return written, status, nil
+}
+
+// placeFile will move the complete file src to dst. Without overwrite dst is only
+// created, if it exists by now errExists is returned and dst is left untouched.
+func placeFile(src, dst string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(src, dst)
+	}
+
+	// A hard link never replaces an existing file
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if os.IsExist(err) {
+		return errExists
+	}
+	if crossDevice(err) {
+		return err
+	}
+
+	// File systems without hard links, reserve the name first
+	// disable G304 (CWE-22): Potential file inclusion via variable
+	// as dst is checked by the caller
+	// #nosec G304
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return errExists
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// sanitizeFilename will strip any path from a client supplied filename (No path traversal)
+func sanitizeFilename(name string) (string, error) {
+	// Browsers on windows might send the full path with backslashes
+	name = strings.ReplaceAll(name, "\\", "/")
+	filenameSlice := strings.Split(name, "/")
+	filenameClean := filenameSlice[len(filenameSlice)-1]
+
+	if filenameClean == "" || filenameClean == "." || filenameClean == ".." {
+		return "", fmt.Errorf("invalid filename %q", name)
+	}
+	if internalPath(filenameClean) {
+		return "", fmt.Errorf("filename %q is reserved", name)
+	}
+
+	return filenameClean, nil
+}
+
+// internalPath will check if the url path points to files goshs uses internally
+// like unfinished uploads
+func internalPath(upath string) bool {
+	for _, part := range strings.Split(upath, "/") {
+		if strings.HasPrefix(part, uploadTempPrefix) {
+			return true
+		}
+	}
+	return false
+}
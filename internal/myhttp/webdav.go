@@ -0,0 +1,313 @@
+package myhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/patrickhener/goshs/internal/myacl"
+	"github.com/patrickhener/goshs/internal/mylog"
+	"github.com/patrickhener/goshs/internal/mymount"
+	"golang.org/x/net/webdav"
+)
+
+// davFileSystem is the webroot as seen by WebDAV clients.
+// It enforces the access control lists and hides files goshs uses internally.
+type davFileSystem struct {
+	webdav.FileSystem
+	fs *FileServer
+}
+
+// davMounts serves the mounted directories to WebDAV clients,
+// with several mounts / is a read only directory listing them
+type davMounts struct {
+	mounts *mymount.Table
+}
+
+// davRoot is the directory listing the mounts
+type davRoot struct {
+	fis []os.FileInfo
+	pos int
+}
+
+// davRootInfo is the file info of the directory listing the mounts
+type davRootInfo struct{}
+
+// davFile filters directory listings the same way processDir does
+type davFile struct {
+	webdav.File
+	ctx  context.Context
+	name string
+	dfs  *davFileSystem
+}
+
+// statusRecorder remembers the status code written by a handler for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader will remember the status and pass it on
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// webdavHandler will return the WebDAV handler serving the webroot below prefix
+func (fs *FileServer) webdavHandler(prefix string) http.Handler {
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: &davFileSystem{FileSystem: davMounts{mounts: fs.mounts}, fs: fs},
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		dav.ServeHTTP(rec, req)
+		mylog.LogRequest(req.RemoteAddr, requestUser(req), req.Method, req.URL.Path, req.Proto, rec.status)
+	})
+}
+
+// Mkdir needs the upload permission on the new directory
+func (d *davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if internalPath(name) || !d.fs.allowedCtx(ctx, name, myacl.Upload) {
+		return os.ErrPermission
+	}
+	return d.FileSystem.Mkdir(ctx, name, perm)
+}
+
+// OpenFile needs read or list permission for reading and upload or overwrite permission for writing
+func (d *davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if internalPath(name) {
+		return nil, os.ErrNotExist
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		want := myacl.Upload
+		if _, err := d.FileSystem.Stat(ctx, name); err == nil {
+			want = myacl.Overwrite
+		}
+		if !d.fs.allowedCtx(ctx, name, want) {
+			return nil, os.ErrPermission
+		}
+	} else if stat, err := d.FileSystem.Stat(ctx, name); err == nil {
+		want := myacl.Read
+		if stat.IsDir() {
+			want = myacl.List
+		}
+		if !d.fs.allowedCtx(ctx, name, want) {
+			return nil, os.ErrPermission
+		}
+	}
+
+	f, err := d.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, ctx: ctx, name: name, dfs: d}, nil
+}
+
+// RemoveAll needs the delete permission on name and everything below it
+func (d *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if internalPath(name) {
+		return os.ErrPermission
+	}
+	if err := d.removable(ctx, name); err != nil {
+		return err
+	}
+	return d.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename needs the delete permission on the source and everything below it and upload
+// on the destination, a replaced destination has to be deletable as well
+func (d *davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if internalPath(oldName) || internalPath(newName) {
+		return os.ErrPermission
+	}
+	if err := d.removable(ctx, oldName); err != nil {
+		return err
+	}
+	want := myacl.Upload
+	if _, err := d.FileSystem.Stat(ctx, newName); err == nil {
+		if err := d.removable(ctx, newName); err != nil {
+			return err
+		}
+		want = myacl.Overwrite
+	}
+	if !d.fs.allowedCtx(ctx, newName, want) {
+		return os.ErrPermission
+	}
+	return d.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// removable will check that the user may delete name and everything below it
+func (d *davFileSystem) removable(ctx context.Context, name string) error {
+	status, err := d.fs.deletable(ctx, path.Clean("/"+name))
+	switch {
+	case err == nil:
+		return nil
+	case status == http.StatusNotFound:
+		return os.ErrNotExist
+	default:
+		return os.ErrPermission
+	}
+}
+
+// Stat hides what the user is neither allowed to read nor to list
+func (d *davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if internalPath(name) {
+		return nil, os.ErrNotExist
+	}
+	stat, err := d.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !d.visible(ctx, name, stat) {
+		return nil, os.ErrNotExist
+	}
+	return stat, nil
+}
+
+// visible will check if the user may see the file or directory
+func (d *davFileSystem) visible(ctx context.Context, name string, stat os.FileInfo) bool {
+	if stat.IsDir() {
+		return d.fs.allowedCtx(ctx, name, myacl.List)
+	}
+	return d.fs.allowedCtx(ctx, name, myacl.Read)
+}
+
+// Readdir will drop internal files and what the user may not see
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	if err != nil {
+		return fis, err
+	}
+
+	// The user is looked up from the context the file has been opened with
+	filtered := make([]os.FileInfo, 0, len(fis))
+	for _, fi := range fis {
+		name := path.Join(f.name, fi.Name())
+		if internalPath(name) || !f.dfs.visible(f.ctx, name, fi) {
+			continue
+		}
+		filtered = append(filtered, fi)
+	}
+
+	return filtered, nil
+}
+
+// dir will return the mounted directory holding name and the name below it
+func (d davMounts) dir(name string) (webdav.Dir, string, error) {
+	name = path.Clean("/" + name)
+	m := d.mounts.Lookup(name)
+	if m == nil {
+		return "", "", os.ErrNotExist
+	}
+	return webdav.Dir(m.Dir), "/" + strings.TrimPrefix(strings.TrimPrefix(name, m.Path), "/"), nil
+}
+
+// root will check if name is the directory listing the mounts
+func (d davMounts) root(name string) bool {
+	return d.mounts.Virtual() && path.Clean("/"+name) == "/"
+}
+
+// Mkdir will create the directory in its mount
+func (d davMounts) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if d.root(name) {
+		return os.ErrExist
+	}
+	dir, rest, err := d.dir(name)
+	if err != nil {
+		return os.ErrPermission
+	}
+	return dir.Mkdir(ctx, rest, perm)
+}
+
+// OpenFile will open the file in its mount or the listing of mounts
+func (d davMounts) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if d.root(name) {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+			return nil, os.ErrPermission
+		}
+		return &davRoot{fis: d.mounts.Stat()}, nil
+	}
+	dir, rest, err := d.dir(name)
+	if err != nil {
+		return nil, err
+	}
+	return dir.OpenFile(ctx, rest, flag, perm)
+}
+
+// RemoveAll will remove the file or directory in its mount
+func (d davMounts) RemoveAll(ctx context.Context, name string) error {
+	if d.root(name) {
+		return os.ErrPermission
+	}
+	dir, rest, err := d.dir(name)
+	if err != nil {
+		return err
+	}
+	return dir.RemoveAll(ctx, rest)
+}
+
+// Rename will rename within a mount, moving between mounts is not possible
+func (d davMounts) Rename(ctx context.Context, oldName, newName string) error {
+	if d.root(oldName) || d.root(newName) || d.mounts.Lookup(oldName) != d.mounts.Lookup(newName) {
+		return os.ErrPermission
+	}
+	dir, oldRest, err := d.dir(oldName)
+	if err != nil {
+		return err
+	}
+	_, newRest, err := d.dir(newName)
+	if err != nil {
+		return err
+	}
+	return dir.Rename(ctx, oldRest, newRest)
+}
+
+// Stat will return the file info from its mount or of the listing of mounts
+func (d davMounts) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if d.root(name) {
+		return davRootInfo{}, nil
+	}
+	dir, rest, err := d.dir(name)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Stat(ctx, rest)
+}
+
+func (r *davRoot) Close() error                   { return nil }
+func (r *davRoot) Read(p []byte) (int, error)     { return 0, io.EOF }
+func (r *davRoot) Write(p []byte) (int, error)    { return 0, os.ErrPermission }
+func (r *davRoot) Seek(int64, int) (int64, error) { return 0, nil }
+func (r *davRoot) Stat() (os.FileInfo, error)     { return davRootInfo{}, nil }
+
+// Readdir will return the mounts like os.File.Readdir
+func (r *davRoot) Readdir(count int) ([]os.FileInfo, error) {
+	rest := r.fis[r.pos:]
+	if count <= 0 {
+		r.pos = len(r.fis)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	r.pos += count
+	return rest[:count], nil
+}
+
+func (davRootInfo) Name() string       { return "/" }
+func (davRootInfo) Size() int64        { return 0 }
+func (davRootInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (davRootInfo) ModTime() time.Time { return time.Now() }
+func (davRootInfo) IsDir() bool        { return true }
+func (davRootInfo) Sys() interface{}   { return nil }
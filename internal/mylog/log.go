@@ -5,11 +5,15 @@ import (
 	"net/http"
 )
 
-//LogRequest will log the request in a uniform way
-func LogRequest(remoteAddr, method, url, proto string, status int) {
+// LogRequest will log the request in a uniform way
+// The user is the authenticated username or "-" if there is none
+func LogRequest(remoteAddr, user, method, url, proto string, status int) {
+	if user == "" {
+		user = "-"
+	}
 	if status == http.StatusInternalServerError || status == http.StatusNotFound {
-		log.Printf("ERROR: %s - - \"%s %s %s\" - %+v", remoteAddr, method, url, proto, status)
+		log.Printf("ERROR: %s - %s \"%s %s %s\" - %+v", remoteAddr, user, method, url, proto, status)
 		return
 	}
-	log.Printf("INFO:  %s - - \"%s %s %s\" - %+v", remoteAddr, method, url, proto, status)
+	log.Printf("INFO:  %s - %s \"%s %s %s\" - %+v", remoteAddr, user, method, url, proto, status)
 }
@@ -0,0 +1,215 @@
+// Package mymount maps the url paths of goshs to directories on disk. Without
+// mounts the webroot is served at /, with mounts every directory is served at its
+// own path and / lists the mounts.
+package mymount
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mount is a directory served at a url path
+type Mount struct {
+	// Path is the url path like /tools
+	Path string
+	// Dir is the directory on disk
+	Dir string
+	// ReadOnly forbids uploading, overwriting and deleting
+	ReadOnly bool
+	// UploadOnly allows new files but forbids overwriting and deleting
+	UploadOnly bool
+}
+
+// Parse will read a mount from /path=dir with an optional :ro or :upload suffix
+func Parse(spec string) (Mount, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return Mount{}, fmt.Errorf("invalid mount %s, use /path=dir[:ro|:upload]", spec)
+	}
+
+	m := Mount{Path: kv[0], Dir: kv[1]}
+	// The suffix is only taken if it is a known flag to keep windows drive letters intact
+	if i := strings.LastIndex(m.Dir, ":"); i > 0 {
+		switch m.Dir[i+1:] {
+		case "ro":
+			m.ReadOnly = true
+			m.Dir = m.Dir[:i]
+		case "upload":
+			m.UploadOnly = true
+			m.Dir = m.Dir[:i]
+		case "rw":
+			m.Dir = m.Dir[:i]
+		}
+	}
+
+	return m, nil
+}
+
+// Table holds the mounts of a server
+type Table struct {
+	mounts []Mount
+	// virtual is set if / lists the mounts instead of serving a directory
+	virtual bool
+}
+
+// New will return the table for the mounts, or for webroot served at / if there are none
+func New(webroot string, mounts []Mount) (*Table, error) {
+	if len(mounts) == 0 {
+		dir, err := filepath.Abs(webroot)
+		if err != nil {
+			return nil, err
+		}
+		return &Table{mounts: []Mount{{Path: "/", Dir: dir}}}, nil
+	}
+
+	t := &Table{virtual: true}
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		m.Path = path.Clean("/" + m.Path)
+		if m.Path == "/" {
+			return nil, fmt.Errorf("mount %s: / lists the mounts, use -d to serve a single directory", m.Dir)
+		}
+		if seen[m.Path] {
+			return nil, fmt.Errorf("mount %s: %s is mounted twice", m.Dir, m.Path)
+		}
+		seen[m.Path] = true
+
+		dir, err := filepath.Abs(expandHome(m.Dir))
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %+v", m.Path, err)
+		}
+		if !stat.IsDir() {
+			return nil, fmt.Errorf("mount %s: %s is not a directory", m.Path, dir)
+		}
+		m.Dir = dir
+		t.mounts = append(t.mounts, m)
+	}
+
+	// Nested mounts would hide each other
+	for _, a := range t.mounts {
+		for _, b := range t.mounts {
+			if a.Path != b.Path && within(b.Path, a.Path) {
+				return nil, fmt.Errorf("mount %s is inside mount %s", b.Path, a.Path)
+			}
+		}
+	}
+
+	sort.Slice(t.mounts, func(i, j int) bool {
+		return t.mounts[i].Path < t.mounts[j].Path
+	})
+
+	return t, nil
+}
+
+// Mounts will return the mounts sorted by path
+func (t *Table) Mounts() []Mount {
+	return t.mounts
+}
+
+// Virtual will check if / lists the mounts
+func (t *Table) Virtual() bool {
+	return t.virtual
+}
+
+// Lookup will return the mount holding the url path, nil for the listing of mounts
+// or paths outside of every mount
+func (t *Table) Lookup(upath string) *Mount {
+	upath = path.Clean("/" + upath)
+	for i := range t.mounts {
+		if within(upath, t.mounts[i].Path) {
+			return &t.mounts[i]
+		}
+	}
+	return nil
+}
+
+// Resolve will return the path on disk for the url path, empty if there is none
+func (t *Table) Resolve(upath string) string {
+	upath = path.Clean("/" + upath)
+	m := t.Lookup(upath)
+	if m == nil {
+		return ""
+	}
+	return filepath.Join(m.Dir, filepath.FromSlash(strings.TrimPrefix(upath, m.Path)))
+}
+
+// Stat will return the file infos of the mounts named like their path for the listing of mounts
+func (t *Table) Stat() []os.FileInfo {
+	fis := make([]os.FileInfo, 0, len(t.mounts))
+	for _, m := range t.mounts {
+		stat, err := os.Stat(m.Dir)
+		if err != nil {
+			// Keep the mount visible, it fails when opened
+			fis = append(fis, mountInfo{name: strings.TrimPrefix(m.Path, "/")})
+			continue
+		}
+		fis = append(fis, mountInfo{FileInfo: stat, name: strings.TrimPrefix(m.Path, "/")})
+	}
+	return fis
+}
+
+// mountInfo is the file info of a mounted directory named like its path
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i mountInfo) Name() string { return i.name }
+func (i mountInfo) IsDir() bool  { return true }
+
+func (i mountInfo) Mode() os.FileMode {
+	if i.FileInfo == nil {
+		return os.ModeDir | 0555
+	}
+	return i.FileInfo.Mode()
+}
+
+func (i mountInfo) ModTime() time.Time {
+	if i.FileInfo == nil {
+		return time.Time{}
+	}
+	return i.FileInfo.ModTime()
+}
+
+func (i mountInfo) Size() int64 {
+	if i.FileInfo == nil {
+		return 0
+	}
+	return i.FileInfo.Size()
+}
+
+func (i mountInfo) Sys() interface{} {
+	if i.FileInfo == nil {
+		return nil
+	}
+	return i.FileInfo.Sys()
+}
+
+// within will check if the url path is prefix or below it
+func within(upath, prefix string) bool {
+	if prefix == "/" || upath == prefix {
+		return true
+	}
+	return strings.HasPrefix(upath, prefix+"/")
+}
+
+// expandHome will replace a leading ~ with the home directory
+func expandHome(dir string) string {
+	if dir != "~" && !strings.HasPrefix(dir, "~/") {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return dir
+	}
+	return filepath.Join(home, strings.TrimPrefix(dir, "~"))
+}
@@ -0,0 +1,158 @@
+package mymount
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Mount
+	}{
+		{"/tools=/opt/tools", Mount{Path: "/tools", Dir: "/opt/tools"}},
+		{"/tools=/opt/tools:ro", Mount{Path: "/tools", Dir: "/opt/tools", ReadOnly: true}},
+		{"/drop=/srv/drop:upload", Mount{Path: "/drop", Dir: "/srv/drop", UploadOnly: true}},
+		{"/loot=/srv/loot:rw", Mount{Path: "/loot", Dir: "/srv/loot"}},
+		// Only known suffixes are taken, windows drive letters stay intact
+		{"/c=C:\\share", Mount{Path: "/c", Dir: "C:\\share"}},
+		{"/c=C:\\share:ro", Mount{Path: "/c", Dir: "C:\\share", ReadOnly: true}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %+v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "/tools", "=/opt/tools", "/tools="} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): no error", spec)
+		}
+	}
+}
+
+func TestWebroot(t *testing.T) {
+	dir := t.TempDir()
+	table, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Virtual() {
+		t.Error("a single webroot is not virtual")
+	}
+	if got := table.Resolve("/a/b.txt"); got != filepath.Join(dir, "a", "b.txt") {
+		t.Errorf("Resolve = %s", got)
+	}
+	if got := table.Resolve("/../../etc/passwd"); got != filepath.Join(dir, "etc", "passwd") {
+		t.Errorf("Resolve escaped the webroot: %s", got)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	tools, loot := t.TempDir(), t.TempDir()
+	table, err := New("", []Mount{
+		{Path: "/tools", Dir: tools, ReadOnly: true},
+		{Path: "loot/", Dir: loot},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !table.Virtual() {
+		t.Error("mounts are virtual")
+	}
+
+	// Sorted by path with clean paths
+	mounts := table.Mounts()
+	if len(mounts) != 2 || mounts[0].Path != "/loot" || mounts[1].Path != "/tools" {
+		t.Fatalf("Mounts() = %+v", mounts)
+	}
+
+	tests := map[string]string{
+		"/tools":          tools,
+		"/tools/a/b.txt":  filepath.Join(tools, "a", "b.txt"),
+		"/loot/../tools":  tools,
+		"/":               "",
+		"/toolsx":         "",
+		"/other/file.txt": "",
+	}
+	for upath, want := range tests {
+		if got := table.Resolve(upath); got != want {
+			t.Errorf("Resolve(%q) = %q, want %q", upath, got, want)
+		}
+	}
+
+	if m := table.Lookup("/tools/a"); m == nil || !m.ReadOnly {
+		t.Errorf("Lookup(/tools/a) = %+v", m)
+	}
+	if m := table.Lookup("/"); m != nil {
+		t.Errorf("Lookup(/) = %+v, want nil", m)
+	}
+}
+
+func TestStat(t *testing.T) {
+	tools := t.TempDir()
+	gone := filepath.Join(t.TempDir(), "gone")
+	if err := os.Mkdir(gone, 0755); err != nil {
+		t.Fatal(err)
+	}
+	table, err := New("", []Mount{{Path: "/tools", Dir: tools}, {Path: "/gone", Dir: gone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	// A mount which went away is still listed
+	fis := table.Stat()
+	if len(fis) != 2 {
+		t.Fatalf("Stat() returned %d entries", len(fis))
+	}
+	for i, name := range []string{"gone", "tools"} {
+		if fis[i].Name() != name || !fis[i].IsDir() {
+			t.Errorf("entry %d = %s dir %v, want directory %s", i, fis[i].Name(), fis[i].IsDir(), name)
+		}
+	}
+}
+
+func TestInvalidMounts(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]Mount{
+		"root":    {{Path: "/", Dir: dir}},
+		"twice":   {{Path: "/a", Dir: dir}, {Path: "/a/", Dir: dir}},
+		"missing": {{Path: "/a", Dir: filepath.Join(dir, "missing")}},
+		"file":    {{Path: "/a", Dir: file}},
+		"nested":  {{Path: "/a", Dir: dir}, {Path: "/a/b", Dir: dir}},
+	}
+	for name, mounts := range tests {
+		if _, err := New("", mounts); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	if got := expandHome("~/share"); got != filepath.Join(home, "share") {
+		t.Errorf("expandHome(~/share) = %s", got)
+	}
+	if got := expandHome("~user/share"); !strings.HasPrefix(got, "~user") {
+		t.Errorf("expandHome(~user/share) = %s", got)
+	}
+}
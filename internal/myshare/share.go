@@ -0,0 +1,256 @@
+package myshare
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrNotFound is returned for unknown or revoked tokens
+	ErrNotFound = errors.New("share link not found")
+	// ErrExpired is returned when the link is expired or all downloads are used up
+	ErrExpired = errors.New("share link expired")
+)
+
+// Link is a token based link to a single file or directory
+type Link struct {
+	Token        string     `json:"token"`
+	Path         string     `json:"path"`
+	IsDir        bool       `json:"is_dir"`
+	Creator      string     `json:"creator"`
+	Created      time.Time  `json:"created"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	MaxDownloads int        `json:"max_downloads"`
+	Downloads    int        `json:"downloads"`
+	HasPassword  bool       `json:"has_password"`
+	passwordHash string
+	// size and sent account the bytes of a shared file so that
+	// resumed downloads can not exceed MaxDownloads copies
+	size int64
+	sent int64
+}
+
+// Store holds the share links, they live as long as goshs is running
+type Store struct {
+	mu    sync.Mutex
+	links map[string]*Link
+}
+
+// New will return an empty Store
+func New() *Store {
+	return &Store{links: make(map[string]*Link)}
+}
+
+// Create will add a link to upath for creator. An expiry or maxDownloads of 0
+// means no limit, an empty password means no password.
+func (s *Store) Create(upath string, isDir bool, creator string, expiry time.Duration, maxDownloads int, password string) (Link, error) {
+	if expiry < 0 || maxDownloads < 0 {
+		return Link{}, errors.New("expiry and downloads must not be negative")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Link{}, err
+	}
+
+	l := &Link{
+		Token:        hex.EncodeToString(b),
+		Path:         upath,
+		IsDir:        isDir,
+		Creator:      creator,
+		Created:      time.Now(),
+		MaxDownloads: maxDownloads,
+	}
+	if expiry > 0 {
+		expires := l.Created.Add(expiry)
+		l.Expires = &expires
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return Link{}, err
+		}
+		l.passwordHash = string(hash)
+		l.HasPassword = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[l.Token] = l
+
+	return *l, nil
+}
+
+// Get will return the link for token as long as it is usable
+func (s *Store) Get(token string) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.get(token)
+	if err != nil {
+		return Link{}, err
+	}
+	return *l, nil
+}
+
+// Check will tell if a download is possible without counting it, resume is
+// set for ranges which do not start at the first byte
+func (s *Store) Check(token string, size int64, resume bool) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.get(token)
+	if err != nil {
+		return Link{}, err
+	}
+	if !l.usable(size, resume) {
+		return Link{}, ErrExpired
+	}
+	return *l, nil
+}
+
+// Use will count a new download of the link, size is the size of a shared file
+// and 0 for directories
+func (s *Store) Use(token string, size int64) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.get(token)
+	if err != nil {
+		return Link{}, err
+	}
+	if !l.usable(size, false) {
+		return Link{}, ErrExpired
+	}
+	l.size = size
+	l.Downloads++
+	return *l, nil
+}
+
+// Resume will check that bytes are left to resume a download of a shared file of size bytes
+func (s *Store) Resume(token string, size int64) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.get(token)
+	if err != nil {
+		return Link{}, err
+	}
+	if !l.usable(size, true) {
+		return Link{}, ErrExpired
+	}
+	l.size = size
+	return *l, nil
+}
+
+// usable will check the download limit. Bytes may be left to resume the
+// last download, but no new one.
+func (l *Link) usable(size int64, resume bool) bool {
+	if l.MaxDownloads == 0 {
+		return true
+	}
+	if l.sent >= int64(l.MaxDownloads)*size && (resume || size > 0) {
+		return false
+	}
+	return resume || l.Downloads < l.MaxDownloads
+}
+
+// Send will account n bytes of a shared file of size bytes and return how many of
+// them may be sent. Links with a download limit send the file at most that many
+// times, counting resumed downloads as well.
+func (s *Store) Send(token string, n, size int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.links[token]
+	if !ok {
+		return 0
+	}
+	if l.MaxDownloads == 0 {
+		return n
+	}
+	l.size = size
+	if left := int64(l.MaxDownloads)*size - l.sent; n > left {
+		n = left
+	}
+	if n < 0 {
+		n = 0
+	}
+	l.sent += n
+	return n
+}
+
+// CheckPassword will verify the password of a link, links without password accept anything
+func (l Link) CheckPassword(password string) bool {
+	if !l.HasPassword {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(l.passwordHash), []byte(password)) == nil
+}
+
+// List will return the links created by creator, all links if creator is empty
+func (s *Store) List(creator string) []Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collect()
+	links := []Link{}
+	for _, l := range s.links {
+		if creator == "" || l.Creator == creator {
+			links = append(links, *l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Created.Before(links[j].Created) })
+
+	return links
+}
+
+// Revoke will remove the link, only its creator may do so unless creator is empty
+func (s *Store) Revoke(token, creator string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.links[token]
+	if !ok || (creator != "" && l.Creator != creator) {
+		return ErrNotFound
+	}
+	delete(s.links, token)
+
+	return nil
+}
+
+// get will look up a usable link, the caller has to hold the lock
+func (s *Store) get(token string) (*Link, error) {
+	l, ok := s.links[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if l.expired() {
+		delete(s.links, token)
+		return nil, ErrExpired
+	}
+	return l, nil
+}
+
+// collect will drop unusable links, the caller has to hold the lock
+func (s *Store) collect() {
+	for token, l := range s.links {
+		if l.expired() {
+			delete(s.links, token)
+		}
+	}
+}
+
+// expired will check the expiry time, the download counter and the bytes sent
+func (l *Link) expired() bool {
+	if l.Expires != nil && time.Now().After(*l.Expires) {
+		return true
+	}
+	return l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads && l.sent >= int64(l.MaxDownloads)*l.size
+}
@@ -0,0 +1,183 @@
+package myshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", time.Hour, 2, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Token) != 32 || l.Path != "/a.txt" || l.Creator != "alice" || l.MaxDownloads != 2 {
+		t.Errorf("unexpected link %+v", l)
+	}
+	if l.Expires == nil || l.Expires.Before(time.Now()) {
+		t.Errorf("expiry %v not in the future", l.Expires)
+	}
+	if !l.HasPassword || l.CheckPassword("wrong") || !l.CheckPassword("secret") {
+		t.Error("password not checked")
+	}
+
+	if _, err := s.Create("/a.txt", false, "alice", -time.Second, 0, ""); err == nil {
+		t.Error("negative expiry: no error")
+	}
+	if _, err := s.Create("/a.txt", false, "alice", 0, -1, ""); err == nil {
+		t.Error("negative downloads: no error")
+	}
+}
+
+func TestGetUnknown(t *testing.T) {
+	if _, err := New().Get("nope"); err != ErrNotFound {
+		t.Errorf("Get = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", time.Millisecond, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get(l.Token); err != ErrExpired {
+		t.Errorf("Get = %v, want %v", err, ErrExpired)
+	}
+	if links := s.List(""); len(links) != 0 {
+		t.Errorf("expired link still listed: %+v", links)
+	}
+}
+
+func TestUseDirectory(t *testing.T) {
+	s := New()
+	l, err := s.Create("/dir", true, "alice", 0, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Use(l.Token, 0); err != nil {
+			t.Fatalf("download %d: %+v", i+1, err)
+		}
+	}
+	if _, err := s.Use(l.Token, 0); err != ErrExpired {
+		t.Errorf("third download = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := s.Use(l.Token, 100); err != nil {
+			t.Fatalf("download %d: %+v", i+1, err)
+		}
+		if n := s.Send(l.Token, 100, 100); n != 100 {
+			t.Fatalf("Send = %d, want 100", n)
+		}
+	}
+}
+
+func TestResumeWithinLimit(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", 0, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The download breaks after 40 bytes and is resumed
+	if _, err := s.Use(l.Token, 100); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Send(l.Token, 40, 100); n != 40 {
+		t.Fatalf("Send = %d, want 40", n)
+	}
+	if _, err := s.Resume(l.Token, 100); err != nil {
+		t.Fatalf("Resume: %+v", err)
+	}
+	if n := s.Send(l.Token, 60, 100); n != 60 {
+		t.Fatalf("Send = %d, want 60", n)
+	}
+
+	// Neither a new nor a resumed download is left
+	if _, err := s.Use(l.Token, 100); err == nil {
+		t.Error("second download allowed")
+	}
+	if _, err := s.Resume(l.Token, 100); err == nil {
+		t.Error("resume after the last byte allowed")
+	}
+}
+
+func TestRangesCanNotExceedLimit(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", 0, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ranges which never start at the first byte are limited by the bytes sent
+	var sent int64
+	for i := 0; i < 10; i++ {
+		if _, err := s.Resume(l.Token, 100); err != nil {
+			break
+		}
+		sent += s.Send(l.Token, 90, 100)
+	}
+	if sent != 200 {
+		t.Errorf("sent %d bytes, want 200", sent)
+	}
+	if _, err := s.Use(l.Token, 100); err == nil {
+		t.Error("new download allowed after the limit")
+	}
+}
+
+func TestListAndRevoke(t *testing.T) {
+	s := New()
+	a, err := s.Create("/a.txt", false, "alice", 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("/b.txt", false, "bob", 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if links := s.List("alice"); len(links) != 1 || links[0].Token != a.Token {
+		t.Errorf("List(alice) = %+v", links)
+	}
+	if links := s.List(""); len(links) != 2 {
+		t.Errorf("List() = %+v", links)
+	}
+
+	if err := s.Revoke(a.Token, "bob"); err != ErrNotFound {
+		t.Errorf("Revoke by bob = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Revoke(a.Token, "alice"); err != nil {
+		t.Errorf("Revoke by alice: %+v", err)
+	}
+	if _, err := s.Get(a.Token); err != ErrNotFound {
+		t.Errorf("Get after revoke = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCheckDoesNotCount(t *testing.T) {
+	s := New()
+	l, err := s.Create("/a.txt", false, "alice", 0, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Check(l.Token, 100, false); err != nil {
+			t.Fatalf("Check %d: %+v", i+1, err)
+		}
+	}
+	if _, err := s.Use(l.Token, 100); err != nil {
+		t.Fatalf("Use after Check: %+v", err)
+	}
+	if _, err := s.Check(l.Token, 100, false); err != ErrExpired {
+		t.Errorf("Check after the last download = %v, want %v", err, ErrExpired)
+	}
+}
@@ -2,11 +2,15 @@ package mysock
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/patrickhener/goshs/internal/myclipboard"
 )
 
 // Packet defines a packet struct
@@ -21,6 +25,14 @@ type SendPacket struct {
 	Content string `json:"content"`
 }
 
+// Event tells the browsers what changed in the clipboard.
+// Type is one of entryAdded, entryDeleted, entryUpdated, cleared or fullSnapshot.
+type Event struct {
+	Type    string              `json:"type"`
+	Entry   *myclipboard.Entry  `json:"entry,omitempty"`
+	Entries []myclipboard.Entry `json:"entries,omitempty"`
+}
+
 const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
@@ -31,8 +43,10 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. It fits the largest clipboard entry
+	// even if every byte is escaped as \u00XX, so that entries which are too large
+	// get a readable error instead of a closed connection.
+	maxMessageSize = 6*myclipboard.MaxEntrySize + 1024
 )
 
 var (
@@ -56,8 +70,18 @@ type Client struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// Decides which paths the client may see.
+	filter Filter
+
+	// The directory the client is looking at, only used by the hub.
+	path string
 }
 
+// Filter decides if a client may see a file or directory.
+// With list set it decides if the client may list the directory.
+type Filter func(upath string, list bool) bool
+
 // readPump pumps messages from the websocket connection to the hub.
 //
 // The application runs readPump in a per-connection goroutine. The application
@@ -65,7 +89,10 @@ type Client struct {
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		if err := c.conn.Close(); err != nil {
 			return
 		}
@@ -86,46 +113,112 @@ func (c *Client) readPump() {
 			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
 				break
 			}
+			if c.hub.closed() {
+				// The connection has been closed on shutdown
+				break
+			}
 
 			log.Printf("Error reading message: %v", err)
 			break
 		}
 
 		// Switch here over possible socket events and pull in handlers
-		switch packet.Type {
-		case "newEntry":
-			entry := string(packet.Content)
-			submitEntry := entry[1 : len(entry)-1]
-			if err := c.hub.cb.AddEntry(submitEntry); err != nil {
-				log.Printf("Error: Error creating Clipboard entry: %+v", err)
-			}
-			c.refreshClipboard()
+		event, err := c.handle(packet)
+		if err != nil {
+			log.Printf("ERROR: Error handling %s event: %+v", packet.Type, err)
+			c.sendError(err)
+			continue
+		}
+		if event != nil {
+			c.hub.Broadcast(event)
+		}
+	}
+}
 
-		case "delEntry":
-			type delID struct {
-				Content int
-			}
-			var id delID
-			if err := json.Unmarshal(packet.Content, &id); err != nil {
-				log.Printf("ERROR: Error reading json packet: %+v", err)
-			}
-			if err := c.hub.cb.DeleteEntry(id.Content); err != nil {
-				log.Printf("ERROR: Error to delete Clipboard entry with id: %s: %+v", string(packet.Content), err)
-			}
-			c.refreshClipboard()
+// handle will execute the event sent by the client and return
+// the change all clients have to be told about
+func (c *Client) handle(packet Packet) (*Event, error) {
+	switch packet.Type {
+	case "newEntry":
+		var content string
+		if err := json.Unmarshal(packet.Content, &content); err != nil {
+			return nil, fmt.Errorf("invalid entry: %+v", err)
+		}
+		entry, err := c.hub.cb.AddEntry(content)
+		if err != nil {
+			return nil, err
+		}
+		return &Event{Type: "entryAdded", Entry: &entry}, nil
 
-		case "clearClipboard":
-			if err := c.hub.cb.ClearClipboard(); err != nil {
-				log.Printf("ERROR: Error clearing clipboard: %+v", err)
-			}
-			c.refreshClipboard()
+	case "delEntry":
+		id, err := parseID(packet.Content)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.hub.cb.DeleteEntry(id); err != nil {
+			return nil, err
+		}
+		return &Event{Type: "entryDeleted", Entry: &myclipboard.Entry{ID: id}}, nil
 
-		default:
-			log.Printf("The event sent via websocket cannot be handeled: %+v", packet.Type)
+	case "editEntry":
+		var edit struct {
+			ID      json.RawMessage `json:"id"`
+			Content string          `json:"content"`
 		}
+		if err := json.Unmarshal(packet.Content, &edit); err != nil {
+			return nil, fmt.Errorf("invalid entry: %+v", err)
+		}
+		id, err := parseID(edit.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := c.hub.cb.EditEntry(id, edit.Content)
+		if err != nil {
+			return nil, err
+		}
+		return &Event{Type: "entryUpdated", Entry: &entry}, nil
+
+	case "subscribe":
+		var dir string
+		if err := json.Unmarshal(packet.Content, &dir); err != nil {
+			return nil, fmt.Errorf("invalid directory: %+v", err)
+		}
+		dir = path.Clean("/" + dir)
+		if !c.filter(dir, true) {
+			return nil, fmt.Errorf("You are not allowed to list %s", dir)
+		}
+		select {
+		case c.hub.subscribe <- subscription{client: c, path: dir}:
+		case <-c.hub.done:
+		}
+		return nil, nil
+
+	case "clearClipboard":
+		if err := c.hub.cb.ClearClipboard(); err != nil {
+			return nil, err
+		}
+		return &Event{Type: "cleared"}, nil
+
+	default:
+		return nil, fmt.Errorf("The event sent via websocket cannot be handeled: %+v", packet.Type)
 	}
 }
 
+// parseID will read an entry id sent as number or as string
+func parseID(raw json.RawMessage) (int, error) {
+	var id int
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if id, err := strconv.Atoi(s); err == nil {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid entry id %s", string(raw))
+}
+
 // writePump pumps messages from the hub to the websocket connection.
 //
 // A goroutine running writePump is started for each connection. The
@@ -134,6 +227,7 @@ func (c *Client) readPump() {
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		c.hub.pumps.Done()
 		ticker.Stop()
 		if err := c.conn.Close(); err != nil {
 			return
@@ -186,29 +280,44 @@ func (c *Client) writePump() {
 	}
 }
 
-// ServeWS will handle the socket connections
-func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// ServeWS will handle the socket connections.
+// The filter decides which directory changes the client is told about.
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, filter Filter) {
 	conn, err := wsupgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade ws: %+v", err)
 		return
 	}
 
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 1024)}
-	client.hub.register <- client
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 1024), filter: filter}
+	select {
+	case client.hub.register <- client:
+	case <-client.hub.done:
+		// The server is shutting down
+		// disable G104 (CWE-703): Errors unhandled
+		// #nosec G104
+		conn.Close()
+		return
+	}
 
 	go client.writePump()
 	go client.readPump()
 }
 
-func (c *Client) refreshClipboard() {
+// sendError will tell only this client that its last event failed
+func (c *Client) sendError(err error) {
 	sendPkg := &SendPacket{
-		Type: "refreshClipboard",
+		Type:    "error",
+		Content: err.Error(),
 	}
-	broadcastMessage, err := json.Marshal(sendPkg)
-	if err != nil {
-		log.Printf("Error: Unable to marshal json data in redirect: %+v", err)
+	message, merr := json.Marshal(sendPkg)
+	if merr != nil {
+		log.Printf("Error: Unable to marshal json data in error: %+v", merr)
+		return
 	}
 
-	c.hub.broadcast <- broadcastMessage
+	select {
+	case c.hub.direct <- directMessage{client: c, message: message}:
+	case <-c.hub.done:
+	}
 }
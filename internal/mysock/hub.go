@@ -1,6 +1,15 @@
 package mysock
 
-import "github.com/patrickhener/goshs/internal/myclipboard"
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path"
+	"sync"
+
+	"github.com/patrickhener/goshs/internal/myclipboard"
+	"github.com/patrickhener/goshs/internal/mywatch"
+)
 
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
@@ -11,24 +20,74 @@ type Hub struct {
 	// Inbound messages from the clients.
 	broadcast chan []byte
 
+	// Messages for a single client.
+	direct chan directMessage
+
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
 
+	// Subscriptions to directory changes.
+	subscribe chan subscription
+
+	// Changes in watched directories.
+	changes chan mywatch.Change
+
 	// Handle clipboard
 	cb *myclipboard.Clipboard
+
+	// Watcher is told which directories the clients are looking at.
+	// It is optional and has to be set before Run.
+	Watcher DirWatcher
+
+	// done is closed to stop the hub, stopped once Run has returned.
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	// pumps counts the write pumps still telling their client goodbye.
+	pumps sync.WaitGroup
+}
+
+// DirWatcher watches directories for changes
+type DirWatcher interface {
+	Watch(upath string) error
+	Unwatch(upath string)
+}
+
+// directMessage is a message for a single client only
+type directMessage struct {
+	client  *Client
+	message []byte
+}
+
+// subscription is a client looking at the directory path
+type subscription struct {
+	client *Client
+	path   string
+}
+
+// DirEvent tells the browsers about a change in the directory they are looking at
+type DirEvent struct {
+	Type string `json:"type"`
+	mywatch.Change
 }
 
 // NewHub will create a new hub
 func NewHub(cb *myclipboard.Clipboard) *Hub {
 	return &Hub{
 		broadcast:  make(chan []byte),
+		direct:     make(chan directMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		subscribe:  make(chan subscription),
+		changes:    make(chan mywatch.Change, 256),
 		clients:    make(map[*Client]bool),
 		cb:         cb,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
 }
 
@@ -38,20 +97,146 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
+			h.pumps.Add(1)
+			// Bring the new client up to date
+			if message, err := json.Marshal(h.snapshot()); err == nil {
+				client.send <- message
+			}
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.remove(client)
+			}
+		case dm := <-h.direct:
+			// The client might be gone already
+			if _, ok := h.clients[dm.client]; ok {
+				h.send(dm.client, dm.message)
+			}
+		case sub := <-h.subscribe:
+			if _, ok := h.clients[sub.client]; ok {
+				h.subscribeClient(sub.client, sub.path)
+			}
+		case change := <-h.changes:
+			message, err := json.Marshal(&DirEvent{Type: "dirChanged", Change: change})
+			if err != nil {
+				log.Printf("Error: Unable to marshal json data in directory change: %+v", err)
+				continue
 			}
-		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if client.path == change.Dir && client.filter(path.Join(change.Dir, change.Name), false) {
+					h.send(client, message)
 				}
 			}
+		case message := <-h.broadcast:
+			for client := range h.clients {
+				h.send(client, message)
+			}
+		case <-h.done:
+			for client := range h.clients {
+				h.remove(client)
+			}
+			close(h.stopped)
+			return
 		}
 	}
 }
+
+// Close will stop the hub and disconnect all clients.
+// It waits until the clients are told or ctx is done.
+func (h *Hub) Close(ctx context.Context) error {
+	h.closeOnce.Do(func() { close(h.done) })
+
+	closed := make(chan struct{})
+	go func() {
+		<-h.stopped
+		h.pumps.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closed will report if the hub is shutting down
+func (h *Hub) closed() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// DirChanged will tell the clients looking at the directory about the change
+func (h *Hub) DirChanged(change mywatch.Change) {
+	select {
+	case h.changes <- change:
+	case <-h.done:
+	}
+}
+
+// send will queue the message for the client or drop the client if it does not keep up
+func (h *Hub) send(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		h.remove(client)
+	}
+}
+
+// remove will forget about the client and stop watching its directory
+func (h *Hub) remove(client *Client) {
+	delete(h.clients, client)
+	close(client.send)
+	if client.path != "" && h.Watcher != nil {
+		h.Watcher.Unwatch(client.path)
+	}
+}
+
+// subscribeClient will move the client to the directory it is looking at now
+func (h *Hub) subscribeClient(client *Client, upath string) {
+	if h.Watcher == nil || client.path == upath {
+		return
+	}
+	if client.path != "" {
+		h.Watcher.Unwatch(client.path)
+		client.path = ""
+	}
+	if err := h.Watcher.Watch(upath); err != nil {
+		// The listing still works, it is just not updated live
+		log.Printf("ERROR: Unable to watch directory %s: %+v", upath, err)
+		return
+	}
+	client.path = upath
+}
+
+// RefreshClipboard will send the whole clipboard to all clients
+func (h *Hub) RefreshClipboard() {
+	h.Broadcast(h.snapshot())
+}
+
+// Broadcast will send the clipboard event to all clients
+func (h *Hub) Broadcast(event *Event) {
+	broadcastMessage, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error: Unable to marshal json data in broadcast: %+v", err)
+		return
+	}
+
+	select {
+	case h.broadcast <- broadcastMessage:
+	case <-h.done:
+	}
+}
+
+// snapshot will return the fullSnapshot event holding all entries
+func (h *Hub) snapshot() *Event {
+	entries, err := h.cb.GetEntries()
+	if err != nil {
+		log.Printf("ERROR: Error reading clipboard: %+v", err)
+	}
+	return &Event{Type: "fullSnapshot", Entries: entries}
+}
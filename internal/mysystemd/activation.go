@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+// Package mysystemd takes over the sockets passed by systemd socket activation
+package mysystemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// Listeners will return the sockets passed by systemd, none if goshs was not socket activated.
+// The environment is cleared so child processes do not take them over as well.
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// disable G104 (CWE-703): Errors unhandled
+	// as unsetting can only fail for invalid names
+	// #nosec G104
+	os.Unsetenv("LISTEN_PID")
+	// #nosec G104
+	os.Unsetenv("LISTEN_FDS")
+	// #nosec G104
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		// The listener holds a duplicate of the descriptor
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("unable to use socket %d passed by systemd: %+v", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
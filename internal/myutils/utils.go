@@ -1,11 +1,9 @@
 package myutils
 
 import (
-	"crypto/rand"
 	"fmt"
-	"log"
-	"math/big"
 	"mime"
+	"net"
 	"strings"
 )
 
@@ -34,20 +32,10 @@ func ReturnExt(n string) string {
 	return "." + extSlice[len(extSlice)-1]
 }
 
-// RandomNumber returns a random int64
-func RandomNumber() (big.Int, error) {
-	n, err := rand.Int(rand.Reader, big.NewInt(1000))
-	if err != nil {
-		log.Printf("Error when generating random number: %+v", err)
-		return *big.NewInt(0), err
-	}
-	return *n, err
-}
-
 // CheckSpecialPath will check a slice of special paths against
 // a folder on disk and return true if it matches
 func CheckSpecialPath(check string) bool {
-	specialPaths := []string{"425bda8487e36deccb30dd24be590b8744e3a28a8bb5a57d9b3fcd24ae09ad3c", "cf985bddf28fed5d5c53b069d6a6ebe601088ca6e20ec5a5a8438f8e1ffd9390", "14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54"}
+	specialPaths := []string{"425bda8487e36deccb30dd24be590b8744e3a28a8bb5a57d9b3fcd24ae09ad3c", "cf985bddf28fed5d5c53b069d6a6ebe601088ca6e20ec5a5a8438f8e1ffd9390", "14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54", "d313bc369f912516df28487e11a73e30922a1f82d6eceaf1d58c46da5dfcf358", "7079731d08e33a51e7987270bebb5dcf6e128e016c43cba52b1af91bd195ab86", "c3bc45ac352fe43ff8f0a1cc26d6cc29f71f536dc417906f8513ea44ed4bb161", "6959097001d10501ac7d54c0bdb8db61420f658f2922cc26e46d536119a31126"}
 
 	for _, item := range specialPaths {
 		if item == check {
@@ -57,3 +45,38 @@ func CheckSpecialPath(check string) bool {
 
 	return false
 }
+
+// ResolveHost will return the address of the network interface if host is the
+// name of one like eth0 or tun0, IPv4 before IPv6. Other hosts are returned unchanged.
+func ResolveHost(host string) (string, error) {
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return host, nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	ipv6 := ""
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+		// Link local addresses would need the zone
+		if ipv6 == "" && !ipnet.IP.IsLinkLocalUnicast() {
+			ipv6 = ipnet.IP.String()
+		}
+	}
+	if ipv6 == "" {
+		return "", fmt.Errorf("interface %s has no usable address", host)
+	}
+	return ipv6, nil
+}
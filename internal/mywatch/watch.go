@@ -0,0 +1,183 @@
+package mywatch
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/patrickhener/goshs/internal/myutils"
+)
+
+// Change is a file which has been added, removed or modified in a watched directory
+type Change struct {
+	Dir  string `json:"path"`
+	Name string `json:"name"`
+	Op   string `json:"op"`
+	// File is set for add and modify as long as the file still exists
+	File *File `json:"file,omitempty"`
+}
+
+// File holds what the listing shows about a changed file
+type File struct {
+	IsDir               bool   `json:"is_dir"`
+	IsSymlink           bool   `json:"is_symlink"`
+	SymlinkTarget       string `json:"symlink_target,omitempty"`
+	Size                int64  `json:"size"`
+	DisplaySize         string `json:"display_size"`
+	LastModified        int64  `json:"mtime"`
+	DisplayLastModified string `json:"display_mtime"`
+}
+
+// Watcher watches the served directories somebody is looking at.
+// Directories are watched as long as at least one client is subscribed.
+type Watcher struct {
+	mu      sync.Mutex
+	resolve func(upath string) string
+	watcher *fsnotify.Watcher
+	refs    map[string]int
+	// dirs maps the watched directories on disk to their url path
+	dirs   map[string]string
+	notify func(Change)
+}
+
+// New will create a Watcher which calls notify on every change.
+// resolve maps url paths to directories on disk.
+func New(resolve func(upath string) string, notify func(Change)) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		resolve: resolve,
+		watcher: fw,
+		refs:    make(map[string]int),
+		dirs:    make(map[string]string),
+		notify:  notify,
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Watch will start watching the directory at the url path upath
+func (w *Watcher) Watch(upath string) error {
+	upath = path.Clean("/" + upath)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.refs[upath] == 0 {
+		// Paths without a directory like the listing of mounts do not change
+		if disk := w.resolve(upath); disk != "" {
+			if err := w.watcher.Add(disk); err != nil {
+				return err
+			}
+			w.dirs[filepath.Clean(disk)] = upath
+		}
+	}
+	w.refs[upath]++
+
+	return nil
+}
+
+// Unwatch will stop watching the directory once the last subscriber is gone
+func (w *Watcher) Unwatch(upath string) {
+	upath = path.Clean("/" + upath)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.refs[upath] == 0 {
+		return
+	}
+	w.refs[upath]--
+	if w.refs[upath] == 0 {
+		delete(w.refs, upath)
+		if disk := w.resolve(upath); disk != "" {
+			delete(w.dirs, filepath.Clean(disk))
+			// The directory might be gone already which removes the watch as well
+			// disable G104 (CWE-703): Errors unhandled
+			// #nosec G104
+			w.watcher.Remove(disk)
+		}
+	}
+}
+
+// Close will stop watching
+func (w *Watcher) Close() error {
+	return w.watcher.Close()
+}
+
+// run will translate the fsnotify events until the watcher is closed
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			op := operation(event.Op)
+			if op == "" {
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[filepath.Dir(event.Name)]
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			change := Change{
+				Dir:  dir,
+				Name: filepath.Base(event.Name),
+				Op:   op,
+			}
+			if op != "remove" {
+				change.File = stat(event.Name)
+			}
+			w.notify(change)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("ERROR: Error watching directories: %+v", err)
+		}
+	}
+}
+
+// stat will describe the file like the listing does, nil if it is gone
+func stat(name string) *File {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil
+	}
+	f := &File{
+		IsDir:               fi.IsDir(),
+		Size:                fi.Size(),
+		DisplaySize:         myutils.ByteCountDecimal(fi.Size()),
+		LastModified:        fi.ModTime().Unix(),
+		DisplayLastModified: fi.ModTime().Format("Mon Jan _2 15:04:05 2006"),
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		f.IsSymlink = true
+		f.SymlinkTarget, _ = os.Readlink(name)
+	}
+	return f
+}
+
+// operation will map the fsnotify operation to add, remove or modify
+func operation(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Create != 0:
+		return "add"
+	case op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		return "remove"
+	case op&fsnotify.Write != 0:
+		return "modify"
+	}
+	// Only chmod is left
+	return ""
+}
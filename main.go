@@ -1,60 +1,351 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/patrickhener/goshs/internal/myauth"
+	"github.com/patrickhener/goshs/internal/myca"
+	"github.com/patrickhener/goshs/internal/myconfig"
 	"github.com/patrickhener/goshs/internal/myhttp"
+	"github.com/patrickhener/goshs/internal/mymount"
+	"github.com/patrickhener/goshs/pkg/goshs"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-const goshsVersion = "v0.0.6"
+const goshsVersion = goshs.Version
 
 var (
 	port       = 8000
 	ip         = "0.0.0.0"
+	listen     = ""
+	qrCode     = false
 	webroot    = "."
+	mounts     = ""
 	ssl        = false
 	selfsigned = false
 	myKey      = ""
 	myCert     = ""
 	basicAuth  = ""
+	authFile   = ""
+	aclFile    = ""
+	aclDir     = false
+	maxUpload  = 0
+	tusExpiry  = 24 * time.Hour
+	tusDir     = ""
+	drainTime  = 30 * time.Second
+	webdav     = false
+	webdavPort = 0
+	noDestruct = false
+	cbFile     = ""
+	caDir      = ""
+	ssNames    = ""
+	ssKeyType  = "rsa"
+	ssValidity = 365
+	ssSubject  = ""
+	clientCA   = ""
+	clientUser = "cn"
+	acmeDomain = ""
+	acmeDir    = ""
+	acmeEmail  = ""
+	acmeCache  = ""
+	acmeRoot   = ""
+	acmeHTTP   = 80
+	configFile = ""
+	authUsers  map[string]string
 )
 
+// settings maps the keys of the config file and the GOSHS_* environment variables to the flags
+var settings = []myconfig.Setting{
+	{Key: "ip", Flag: "i"},
+	{Key: "port", Flag: "p"},
+	{Key: "listen", Flag: "l"},
+	{Key: "qr_code", Flag: "qr"},
+	{Key: "webroot", Flag: "d"},
+	{Key: "mounts", Flag: "m"},
+	{Key: "max_upload", Flag: "mu"},
+	{Key: "tus_expiry", Flag: "te"},
+	{Key: "tus_dir", Flag: "td"},
+	{Key: "drain_timeout", Flag: "dt"},
+	{Key: "no_destructive", Flag: "nd"},
+	{Key: "clipboard_file", Flag: "cf"},
+	{Key: "webdav.enabled", Flag: "w"},
+	{Key: "webdav.port", Flag: "wp"},
+	{Key: "tls.enabled", Flag: "s"},
+	{Key: "tls.self_signed", Flag: "ss"},
+	{Key: "tls.key", Flag: "sk"},
+	{Key: "tls.cert", Flag: "sc"},
+	{Key: "tls.ca_dir", Flag: "sca"},
+	{Key: "tls.names", Flag: "ssn"},
+	{Key: "tls.key_type", Flag: "ssk"},
+	{Key: "tls.validity", Flag: "ssv"},
+	{Key: "tls.subject", Flag: "sss"},
+	{Key: "tls.client_ca", Flag: "sm"},
+	{Key: "tls.client_user", Flag: "smu"},
+	{Key: "tls.acme.domains", Flag: "sa"},
+	{Key: "tls.acme.directory", Flag: "sad"},
+	{Key: "tls.acme.email", Flag: "sae"},
+	{Key: "tls.acme.cache", Flag: "sac"},
+	{Key: "tls.acme.root_ca", Flag: "sar"},
+	{Key: "tls.acme.http_port", Flag: "sah"},
+	{Key: "auth.password", Flag: "P"},
+	{Key: "auth.htpasswd", Flag: "H"},
+	{Key: "acl.file", Flag: "acl"},
+	{Key: "acl.dir_files", Flag: "acld"},
+}
+
+// hashPassword implements the hash-password subcommand
+func hashPassword(args []string) {
+	fset := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	user := fset.String("u", "", "user")
+	fset.Usage = func() {
+		fmt.Printf("Usage: %s hash-password [-u user]\n\n", os.Args[0])
+		fmt.Println("Reads a password from stdin and prints a bcrypt hash for use in a htpasswd file.")
+		fmt.Println("")
+		fmt.Println("\t-u\tPrint a complete htpasswd line for this user")
+	}
+	// disable G104 (CWE-703): Errors unhandled
+	// as flag.ExitOnError is used
+	// #nosec G104
+	fset.Parse(args)
+
+	password := readPassword()
+
+	hash, err := myauth.HashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing password: %+v\n", err)
+		os.Exit(1)
+	}
+
+	if *user != "" {
+		fmt.Printf("%s:%s\n", *user, hash)
+	} else {
+		fmt.Println(hash)
+	}
+}
+
+// readPassword will ask for a password twice on a terminal or read one line from stdin
+func readPassword() string {
+	var password string
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		first, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading password: %+v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, "Repeat password: ")
+		second, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading password: %+v\n", err)
+			os.Exit(1)
+		}
+		if string(first) != string(second) {
+			fmt.Fprintln(os.Stderr, "Passwords do not match")
+			os.Exit(1)
+		}
+		password = string(first)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "Error reading password: %+v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "Password must not be empty")
+		os.Exit(1)
+	}
+
+	return password
+}
+
+// clientCert implements the client-cert subcommand
+func clientCert(args []string) {
+	fset := flag.NewFlagSet("client-cert", flag.ExitOnError)
+	dir := fset.String("sca", "", "ca dir")
+	user := fset.String("u", "", "user")
+	email := fset.String("e", "", "email")
+	out := fset.String("o", "", "output")
+	keyType := fset.String("ssk", "rsa", "key type")
+	validity := fset.Int("ssv", 365, "validity")
+	fset.Usage = func() {
+		fmt.Printf("Usage: %s client-cert -sca dir -u user [-e email] [-o file]\n\n", os.Args[0])
+		fmt.Println("Issues a client certificate from the goshs CA for use with -sm ca.")
+		fmt.Println("Reads the password of the PKCS#12 bundle from stdin.")
+		fmt.Println("")
+		fmt.Println("\t-sca\tDirectory of the goshs CA")
+		fmt.Println("\t-u\tUser name (common name of the certificate)")
+		fmt.Println("\t-e\tEmail addresses of the certificate (comma separated)")
+		fmt.Println("\t-o\tPKCS#12 file to write\t(default: <user>.p12)")
+		fmt.Println("\t-ssk\tKey type (rsa or ecdsa)\t(default: rsa)")
+		fmt.Println("\t-ssv\tValidity in days\t(default: 365)")
+	}
+	// disable G104 (CWE-703): Errors unhandled
+	// as flag.ExitOnError is used
+	// #nosec G104
+	fset.Parse(args)
+
+	if *dir == "" || *user == "" {
+		fset.Usage()
+		os.Exit(1)
+	}
+	if *out == "" {
+		*out = *user + ".p12"
+	}
+
+	ca, err := myca.LoadCA(*dir, myca.Options{
+		KeyType:  *keyType,
+		Validity: time.Duration(*validity) * 24 * time.Hour,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading CA: %+v\n", err)
+		os.Exit(1)
+	}
+
+	password := readPassword()
+
+	p12, cert, err := ca.ClientCert(*user, splitList(*email), password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error issuing client certificate: %+v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*out, p12, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing client certificate: %+v\n", err)
+		os.Exit(1)
+	}
+
+	fingerprint256, _ := myca.Sum(cert.Raw)
+	fmt.Printf("Wrote client certificate for %s to %s\n", *user, *out)
+	fmt.Printf("SHA-256 Fingerprint: %s\n", fingerprint256)
+}
+
+// splitList will split a comma separated flag value
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func init() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		hashPassword(os.Args[2:])
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "client-cert" {
+		clientCert(os.Args[2:])
+		os.Exit(0)
+	}
+
 	wd, _ := os.Getwd()
 
 	// flags
 	flag.StringVar(&ip, "i", ip, "ip")
 	flag.IntVar(&port, "p", port, "port")
+	flag.StringVar(&listen, "l", listen, "listen addresses")
+	flag.BoolVar(&qrCode, "qr", qrCode, "qr codes")
 	flag.StringVar(&webroot, "d", wd, "web root")
+	flag.StringVar(&mounts, "m", mounts, "mounts")
+	flag.IntVar(&maxUpload, "mu", maxUpload, "max upload size")
+	flag.DurationVar(&tusExpiry, "te", tusExpiry, "tus expiry")
+	flag.StringVar(&tusDir, "td", tusDir, "tus staging dir")
+	flag.DurationVar(&drainTime, "dt", drainTime, "drain timeout")
+	flag.BoolVar(&webdav, "w", webdav, "webdav")
+	flag.IntVar(&webdavPort, "wp", webdavPort, "webdav port")
+	flag.BoolVar(&noDestruct, "nd", noDestruct, "no destructive operations")
+	flag.StringVar(&cbFile, "cf", cbFile, "clipboard file")
 	flag.BoolVar(&ssl, "s", ssl, "tls")
 	flag.BoolVar(&selfsigned, "ss", selfsigned, "self-signed")
 	flag.StringVar(&myKey, "sk", myKey, "server key")
 	flag.StringVar(&myCert, "sc", myCert, "server cert")
+	flag.StringVar(&caDir, "sca", caDir, "self-signed ca dir")
+	flag.StringVar(&ssNames, "ssn", ssNames, "self-signed names")
+	flag.StringVar(&ssKeyType, "ssk", ssKeyType, "self-signed key type")
+	flag.IntVar(&ssValidity, "ssv", ssValidity, "self-signed validity")
+	flag.StringVar(&ssSubject, "sss", ssSubject, "self-signed subject")
+	flag.StringVar(&clientCA, "sm", clientCA, "mtls client ca")
+	flag.StringVar(&clientUser, "smu", clientUser, "mtls user field")
+	flag.StringVar(&acmeDomain, "sa", acmeDomain, "acme domains")
+	flag.StringVar(&acmeDir, "sad", acmeDir, "acme directory url")
+	flag.StringVar(&acmeEmail, "sae", acmeEmail, "acme email")
+	flag.StringVar(&acmeCache, "sac", acmeCache, "acme cache dir")
+	flag.StringVar(&acmeRoot, "sar", acmeRoot, "acme root ca")
+	flag.IntVar(&acmeHTTP, "sah", acmeHTTP, "acme http-01 port")
 	flag.StringVar(&basicAuth, "P", basicAuth, "basic auth")
+	flag.StringVar(&authFile, "H", authFile, "htpasswd file")
+	flag.StringVar(&aclFile, "acl", aclFile, "acl policy file")
+	flag.BoolVar(&aclDir, "acld", aclDir, "acl per directory files")
+	flag.StringVar(&configFile, "c", configFile, "config file")
+	printConfig := flag.Bool("print-config", false, "print config")
 	version := flag.Bool("v", false, "goshs version")
 
 	flag.Usage = func() {
 		fmt.Printf("goshs %s\n", goshsVersion)
-		fmt.Printf("Usage: %s [options]\n\n", os.Args[0])
+		fmt.Printf("Usage: %s [options]\n", os.Args[0])
+		fmt.Printf("       %s hash-password [-u user]\n", os.Args[0])
+		fmt.Printf("       %s client-cert -sca dir -u user [-e email] [-o file]\n\n", os.Args[0])
 		fmt.Println("Web server options:")
-		fmt.Println("\t-i\tThe ip to listen on\t(default: 0.0.0.0)")
+		fmt.Println("\t-i\tThe ip or interface (eth0, tun0) to listen on\t(default: 0.0.0.0)")
 		fmt.Println("\t-p\tThe port to listen on\t(default: 8000)")
+		fmt.Println("\t-l\tComma separated addresses to listen on instead, host:port, http://host:port, https://host:port, unix:/path or systemd\t(default: -i and -p)")
+		fmt.Println("\t-qr\tPrint QR codes of the urls goshs is reachable at\t(default: false)")
 		fmt.Println("\t-d\tThe web root directory\t(default: current working path)")
+		fmt.Println("\t-m\tComma separated directories to serve instead, /path=dir with optional :ro or :upload\t(default: -d)")
+		fmt.Println("\t-mu\tMaximum upload size per request in MB\t(default: unlimited)")
+		fmt.Println("\t-te\tExpiry of unfinished resumable uploads\t(default: 24h)")
+		fmt.Println("\t-td\tDirectory to keep unfinished resumable uploads in\t(default: .goshs-upload-tus in the webroot)")
+		fmt.Println("\t-dt\tHow long running transfers may take on shutdown\t(default: 30s)")
+		fmt.Println("\t-w\tAlso serve the web root via WebDAV\t(default: at /webdav)")
+		fmt.Println("\t-wp\tServe WebDAV on its own port instead of /webdav")
+		fmt.Println("\t-nd\tDisable deleting, renaming and overwriting files")
+		fmt.Println("\t-cf\tKeep the clipboard in this file across restarts\t(default: in memory only)")
 		fmt.Println("")
 		fmt.Println("TLS options:")
 		fmt.Println("\t-s\tUse TLS")
 		fmt.Println("\t-ss\tUse a self-signed certificate")
 		fmt.Println("\t-sk\tPath to server key")
 		fmt.Println("\t-sc\tPath to server certificate")
+		fmt.Println("\t-sca\tKeep the self-signed CA in this directory and reuse it")
+		fmt.Println("\t-ssn\tAdditional DNS names and IPs of the self-signed certificate (comma separated)")
+		fmt.Println("\t-ssk\tKey type of the self-signed certificate (rsa, ecdsa or ed25519)\t(default: rsa)")
+		fmt.Println("\t-ssv\tValidity of the self-signed certificate in days\t(default: 365)")
+		fmt.Println("\t-sss\tSubject of the self-signed certificate like CN=goshs,O=example,C=DE")
+		fmt.Println("\t-sm\tRequire client certificates signed by this CA bundle (pem), ca for the goshs CA of -sca")
+		fmt.Println("\t-smu\tTake the user from the client certificate's cn, email or dns\t(default: cn)")
+		fmt.Println("\t-sa\tGet a certificate via ACME for these domains (comma separated)")
+		fmt.Println("\t-sad\tACME directory url\t(default: Let's Encrypt)")
+		fmt.Println("\t-sae\tEmail address for the ACME account")
+		fmt.Println("\t-sac\tDirectory to store ACME certificates in\t(default: user cache dir)")
+		fmt.Println("\t-sar\tRoot certificate of an internal ACME server (pem)")
+		fmt.Println("\t-sah\tPort for ACME HTTP-01 challenges, 0 to disable\t(default: 80)")
 		fmt.Println("")
 		fmt.Println("Authentication options:")
 		fmt.Println("\t-P\tUse basic authentication password (user: gopher)")
+		fmt.Println("\t-H\tUse users from a htpasswd file (bcrypt, SHA-crypt, {SHA} or plain)")
+		fmt.Println("")
+		fmt.Println("Access control options:")
+		fmt.Println("\t-acl\tPath to access control policy file (json)")
+		fmt.Println("\t-acld\tHonor per directory .goshs access control files")
 		fmt.Println("")
 		fmt.Println("Misc options:")
+		fmt.Println("\t-c\tRead the settings from this yaml file\t(default: <user config dir>/goshs/goshs.yaml)")
+		fmt.Println("\t-print-config\tPrint the effective configuration and exit")
 		fmt.Println("\t-v\tPrint the current goshs version")
 	}
 
@@ -64,22 +355,84 @@ func init() {
 		fmt.Printf("goshs version is: %+v\n", goshsVersion)
 		os.Exit(0)
 	}
+
+	// defaults < config file < GOSHS_* environment < flags
+	config, err := myconfig.Merge(flag.CommandLine, settings, configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration: %+v\n", err)
+		os.Exit(1)
+	}
+	authUsers = config.Users
+	configFile = config.File
+
+	if *printConfig {
+		out, err := config.Dump()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing configuration: %+v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		os.Exit(0)
+	}
 }
 
 func main() {
 	// Random Seed generation (used for CA serial)
 	rand.Seed(time.Now().UnixNano())
+
+	var mountList []mymount.Mount
+	for _, spec := range splitList(mounts) {
+		m, err := mymount.Parse(spec)
+		if err != nil {
+			log.Fatalf("%+v\n", err)
+		}
+		mountList = append(mountList, m)
+	}
+
 	// Setup the custom file server
 	server := &myhttp.FileServer{
 		IP:         ip,
 		Port:       port,
+		Listeners:  splitList(listen),
+		QRCode:     qrCode,
 		Webroot:    webroot,
-		SSL:        ssl,
-		SelfSigned: selfsigned,
-		MyCert:     myCert,
-		MyKey:      myKey,
-		BasicAuth:  basicAuth,
-		Version:    goshsVersion,
-	}
-	server.Start()
+		Mounts:     mountList,
+		SSL:        ssl || acmeDomain != "" || caDir != "",
+		SelfSigned: selfsigned || caDir != "",
+		CADir:      caDir,
+		CertOptions: myca.Options{
+			Names:    splitList(ssNames),
+			KeyType:  ssKeyType,
+			Validity: time.Duration(ssValidity) * 24 * time.Hour,
+			Subject:  ssSubject,
+		},
+		MyCert:         myCert,
+		MyKey:          myKey,
+		ClientCA:       clientCA,
+		ClientCertUser: clientUser,
+		ACMEDomains:    splitList(acmeDomain),
+		ACMEDirectory:  acmeDir,
+		ACMEEmail:      acmeEmail,
+		ACMECache:      acmeCache,
+		ACMERootCA:     acmeRoot,
+		ACMEHTTPPort:   acmeHTTP,
+		BasicAuth:      basicAuth,
+		AuthFile:       authFile,
+		AuthUsers:      authUsers,
+		ACLFile:        aclFile,
+		ACLDirFiles:    aclDir,
+		MaxUpload:      int64(maxUpload) << 20,
+		TusExpiry:      tusExpiry,
+		TusDir:         tusDir,
+		DrainTimeout:   drainTime,
+		WebDAV:         webdav || webdavPort != 0,
+		WebDAVPort:     webdavPort,
+		NoDestructive:  noDestruct,
+		ClipboardFile:  cbFile,
+		ConfigFile:     configFile,
+		Version:        goshsVersion,
+	}
+	if err := server.Start(); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
 }
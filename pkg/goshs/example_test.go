@@ -0,0 +1,55 @@
+package goshs_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/patrickhener/goshs/pkg/goshs"
+)
+
+// Serve a directory with basic auth from an httptest.Server and upload a file to it
+func Example() {
+	dir, err := ioutil.TempDir("", "goshs-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv, err := goshs.New(goshs.WithWebroot(dir), goshs.WithBasicAuth("secret"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/hello.txt", strings.NewReader("Hello, gopher!"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.SetBasicAuth("gopher", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.Status)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(content))
+
+	// Output:
+	// 201 Created
+	// Hello, gopher!
+}
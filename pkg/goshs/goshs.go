@@ -0,0 +1,312 @@
+// Package goshs embeds the goshs file server into other programs.
+//
+// New takes options like the command line flags of goshs. The server can then
+// be run on its own with ListenAndServe, on any listener with Serve, or its
+// Handler can be served by an http.Server or httptest.Server of your own:
+//
+//	srv, err := goshs.New(goshs.WithWebroot("/srv/files"), goshs.WithBasicAuth("secret"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Shutdown(context.Background())
+//	ts := httptest.NewServer(srv.Handler())
+//
+// There is no base path option. The web interface, websocket, uploads and share
+// links use absolute paths, so the handler has to be mounted at / of a host or
+// port and must not be wrapped in http.StripPrefix or a sub router.
+package goshs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/patrickhener/goshs/internal/myca"
+	"github.com/patrickhener/goshs/internal/myhttp"
+	"github.com/patrickhener/goshs/internal/mymount"
+)
+
+// Version is the goshs version
+const Version = "v0.0.6"
+
+// Server is an embedded goshs
+type Server struct {
+	fs *myhttp.FileServer
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// ACMEConfig holds the settings to get certificates from an ACME server.
+// Certificates are cached in CacheDir and renewed automatically.
+type ACMEConfig struct {
+	Domains      []string
+	DirectoryURL string
+	Email        string
+	CacheDir     string
+	// RootCA is a pem file with the root of an internal ACME server
+	RootCA string
+	// HTTPPort answers HTTP-01 challenges in ListenAndServe, 0 only answers TLS-ALPN-01 challenges
+	HTTPPort int
+}
+
+// CertOptions holds the settings of self-signed certificates, empty fields keep the defaults
+type CertOptions struct {
+	// Names are additional DNS names or IPs of the certificate
+	Names []string
+	// KeyType is rsa, ecdsa or ed25519
+	KeyType string
+	// Validity is how long the certificate is valid
+	Validity time.Duration
+	// Subject is like CN=goshs,O=hesec.de
+	Subject string
+}
+
+// Mount is a directory served at a url path
+type Mount struct {
+	// Path is the url path like /tools
+	Path string
+	// Dir is the directory on disk
+	Dir string
+	// ReadOnly forbids uploading, overwriting and deleting
+	ReadOnly bool
+	// UploadOnly allows new files but forbids overwriting and deleting
+	UploadOnly bool
+}
+
+// New will create and set up a Server. Without options it serves the
+// working directory on 0.0.0.0 port 8000 without tls or authentication.
+// Nothing is listened on before Serve or ListenAndServe is called.
+func New(opts ...Option) (*Server, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{fs: &myhttp.FileServer{
+		IP:             "0.0.0.0",
+		Port:           8000,
+		Webroot:        wd,
+		ClientCertUser: "cn",
+		CertOptions:    myca.Options{KeyType: "rsa"},
+		TusExpiry:      24 * time.Hour,
+		Version:        Version,
+	}}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.fs.Setup(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithWebroot will serve dir
+func WithWebroot(dir string) Option {
+	return func(s *Server) {
+		s.fs.Webroot = dir
+	}
+}
+
+// WithMounts will serve the directories at their paths instead of the webroot, / lists the mounts
+func WithMounts(mounts ...Mount) Option {
+	return func(s *Server) {
+		for _, m := range mounts {
+			s.fs.Mounts = append(s.fs.Mounts, mymount.Mount{
+				Path:       m.Path,
+				Dir:        m.Dir,
+				ReadOnly:   m.ReadOnly,
+				UploadOnly: m.UploadOnly,
+			})
+		}
+	}
+}
+
+// WithAddress will listen on ip and port in ListenAndServe, ip may be an interface name
+func WithAddress(ip string, port int) Option {
+	return func(s *Server) {
+		s.fs.IP = ip
+		s.fs.Port = port
+	}
+}
+
+// WithListeners will listen on the addresses in ListenAndServe instead of the address.
+// An address is host:port, http://host:port, https://host:port, unix:/path to a
+// unix domain socket or systemd for the sockets of systemd socket activation.
+func WithListeners(addresses ...string) Option {
+	return func(s *Server) {
+		s.fs.Listeners = addresses
+	}
+}
+
+// WithQRCode will print QR codes of the urls goshs is reachable at in ListenAndServe
+func WithQRCode() Option {
+	return func(s *Server) {
+		s.fs.QRCode = true
+	}
+}
+
+// WithBasicAuth will require basic auth as user gopher with password
+func WithBasicAuth(password string) Option {
+	return func(s *Server) {
+		s.fs.BasicAuth = password
+	}
+}
+
+// WithHtpasswd will require basic auth with the users of an htpasswd file
+func WithHtpasswd(file string) Option {
+	return func(s *Server) {
+		s.fs.AuthFile = file
+	}
+}
+
+// WithUsers will require basic auth with users mapping names to bcrypt hashes
+func WithUsers(users map[string]string) Option {
+	return func(s *Server) {
+		s.fs.AuthUsers = users
+	}
+}
+
+// WithACL will apply the access control policy file, with dirFiles
+// also from .goshs files in the served directories
+func WithACL(file string, dirFiles bool) Option {
+	return func(s *Server) {
+		s.fs.ACLFile = file
+		s.fs.ACLDirFiles = dirFiles
+	}
+}
+
+// WithMaxUpload will limit uploads to bytes, 0 is unlimited
+func WithMaxUpload(bytes int64) Option {
+	return func(s *Server) {
+		s.fs.MaxUpload = bytes
+	}
+}
+
+// WithTusExpiry will remove unfinished resumable uploads after d
+func WithTusExpiry(d time.Duration) Option {
+	return func(s *Server) {
+		s.fs.TusExpiry = d
+	}
+}
+
+// WithTusDir will keep unfinished resumable uploads in dir instead of a hidden directory in the webroot
+func WithTusDir(dir string) Option {
+	return func(s *Server) {
+		s.fs.TusDir = dir
+	}
+}
+
+// WithWebDAV will serve WebDAV at /webdav, or on its own port in ListenAndServe if port is not 0
+func WithWebDAV(port int) Option {
+	return func(s *Server) {
+		s.fs.WebDAV = true
+		s.fs.WebDAVPort = port
+	}
+}
+
+// WithNoDestructive will disable deleting and overwriting files
+func WithNoDestructive() Option {
+	return func(s *Server) {
+		s.fs.NoDestructive = true
+	}
+}
+
+// WithClipboardFile will persist the clipboard in file
+func WithClipboardFile(file string) Option {
+	return func(s *Server) {
+		s.fs.ClipboardFile = file
+	}
+}
+
+// WithSelfSigned will serve https with a self-signed certificate. The CA is
+// kept in caDir if not empty.
+func WithSelfSigned(caDir string, opts CertOptions) Option {
+	return func(s *Server) {
+		s.fs.SSL = true
+		s.fs.SelfSigned = true
+		s.fs.CADir = caDir
+		s.fs.CertOptions.Names = opts.Names
+		s.fs.CertOptions.Validity = opts.Validity
+		s.fs.CertOptions.Subject = opts.Subject
+		if opts.KeyType != "" {
+			s.fs.CertOptions.KeyType = opts.KeyType
+		}
+	}
+}
+
+// WithCertificate will serve https with the certificate and key files,
+// which are reloaded when they change
+func WithCertificate(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.fs.SSL = true
+		s.fs.MyCert = certFile
+		s.fs.MyKey = keyFile
+	}
+}
+
+// WithACME will serve https with certificates from an ACME server
+func WithACME(c ACMEConfig) Option {
+	return func(s *Server) {
+		s.fs.SSL = true
+		s.fs.ACMEDomains = c.Domains
+		s.fs.ACMEDirectory = c.DirectoryURL
+		s.fs.ACMEEmail = c.Email
+		s.fs.ACMECache = c.CacheDir
+		s.fs.ACMERootCA = c.RootCA
+		s.fs.ACMEHTTPPort = c.HTTPPort
+	}
+}
+
+// WithClientCA will require client certificates signed by the CAs in the pem file,
+// or by the self-signed goshs CA if file is "ca". The user is taken from userField,
+// which is cn, email or dns.
+func WithClientCA(file, userField string) Option {
+	return func(s *Server) {
+		s.fs.ClientCA = file
+		s.fs.ClientCertUser = userField
+	}
+}
+
+// WithDrainTimeout will give running transfers d to finish on shutdown
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.fs.DrainTimeout = d
+	}
+}
+
+// Handler will return the handler serving goshs. It has to be mounted at /,
+// below a path prefix the links of the web interface do not resolve.
+func (s *Server) Handler() http.Handler {
+	return s.fs.Handler()
+}
+
+// TLSConfig will return the tls config of the server, nil if it serves plain http.
+// Use it with httptest.NewUnstartedServer to test https and client certificates.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.fs.TLSConfig()
+}
+
+// Serve will serve goshs on l, with tls if configured, until Shutdown is called.
+// After Shutdown it returns http.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.fs.Serve(l)
+}
+
+// ListenAndServe will serve goshs on the configured address until Shutdown is called,
+// it returns nil then
+func (s *Server) ListenAndServe() error {
+	return s.fs.ListenAndServe()
+}
+
+// Shutdown will stop the servers and wait for running requests until ctx is done.
+// Afterwards the websocket clients are disconnected, the directory and certificate
+// watchers and the collection of unfinished uploads are stopped and the clipboard
+// is saved. It has to be called even if only the Handler is used.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.fs.Shutdown(ctx)
+}
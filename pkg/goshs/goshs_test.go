@@ -0,0 +1,152 @@
+package goshs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickhener/goshs/pkg/goshs"
+	"github.com/phogolabs/parcello"
+)
+
+func TestMain(m *testing.M) {
+	// The templates are read from the source tree, tests run without a bundle
+	parcello.Manager = parcello.Dir("../../static")
+	os.Exit(m.Run())
+}
+
+// newServer will serve a goshs with opts from an httptest.Server until the test ends
+func newServer(t *testing.T, opts ...goshs.Option) *httptest.Server {
+	t.Helper()
+	opts = append([]goshs.Option{goshs.WithTusDir(filepath.Join(t.TempDir(), "tus"))}, opts...)
+	srv, err := goshs.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return ts
+}
+
+// get will send an authenticated GET request accepting json
+func get(t *testing.T, ts *httptest.Server, upath, password string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+upath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if password != "" {
+		req.SetBasicAuth("gopher", password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestAuth(t *testing.T) {
+	ts := newServer(t, goshs.WithWebroot(t.TempDir()), goshs.WithBasicAuth("secret"))
+
+	tests := map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	}
+	for password, want := range tests {
+		if resp, _ := get(t, ts, "/", password); resp.StatusCode != want {
+			t.Errorf("password %q: %d, want %d", password, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestUploadAndList(t *testing.T) {
+	dir := t.TempDir()
+	ts := newServer(t, goshs.WithWebroot(dir), goshs.WithMaxUpload(1024))
+
+	// The upload form of the web interface posts to the directory
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("files", "form.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write([]byte("from the form")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Post(ts.URL+"/", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("form upload: %d", resp.StatusCode)
+	}
+
+	// Command line clients put the file at its path
+	put := func(upath string, content []byte) int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+upath, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put("/put.txt", []byte("from curl")); status != http.StatusCreated {
+		t.Errorf("PUT: %d", status)
+	}
+	if status := put("/large.bin", make([]byte, 2048)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT above the maximum upload size: %d", status)
+	}
+
+	for name, want := range map[string]string{"form.txt": "from the form", "put.txt": "from curl"} {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(content) != want {
+			t.Errorf("%s: %q %v", name, content, err)
+		}
+	}
+
+	resp, listing := get(t, ts, "/", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("listing: %d", resp.StatusCode)
+	}
+	var d struct {
+		Content []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(listing, &d); err != nil {
+		t.Fatalf("listing is no json: %+v\n%s", err, listing)
+	}
+	if len(d.Content) != 2 || d.Content[0].Name != "form.txt" || d.Content[1].Name != "put.txt" || d.Content[1].Size != 9 {
+		t.Errorf("listing: %s", listing)
+	}
+}
@@ -1 +1,3 @@
-$(document).ready(function(){$("#tableData").DataTable({paging:false,language:{info:"_TOTAL_ items"},order:[[2,"asc"]],columnDefs:[{targets:[0,1,5],orderable:false}]})});var input=document.querySelector(".custom-file-input");var label=input.nextElementSibling;varlabelVal=label.innerText;input.addEventListener("change",function(e){var fileName="";if(this.files&&this.files.length>1)fileName=" "+(this.getAttribute("data-multiple-caption")||"").replace("{count}",this.files.length);else{fileName=" "+e.target.value.split("\\").pop()}if(fileName)label.querySelector("span").innerHTML=fileName;else label.innerText=labelVal});var checkboxes=document.querySelectorAll(".downloadBulkCheckbox");Array.prototype.forEach.call(checkboxes,function(cb){cb.addEventListener("change",function(){checkedBoxes=document.querySelectorAll("input[type=checkbox]:checked").length;if(checkedBoxes>=1){document.getElementById("downloadBulkButton").style.display="block"}else{document.getElementById("downloadBulkButton").style.display="none"}})});function selectAll(){Array.prototype.forEach.call(checkboxes,function(cb){cb.checked=true});document.getElementById("downloadBulkButton").style.display="block"}function selectNone(){Array.prototype.forEach.call(checkboxes,function(cb){cb.checked=false});document.getElementById("downloadBulkButton").style.display="none"}var wsURL="ws://"+window.location.host+"/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/ws";var connection=new WebSocket(wsURL);connection.onopen=function(){console.log("Connected via WebSockets")};connection.onclose=function(){console.log("Connection has been closed by WebSocket Server")};connection.onerror=function(e){console.log("Websocket error: ",e)};connection.onmessage=function(m){try{var message=JSON.parse(m.data);if(message["type"]=="refreshClipboard"){location.reload()}}catch(e){console.log("Error reading message: ",e)}};function sendEntry(e){e.preventDefault();entryfield=document.getElementById("cbEntry");var text=entryfield.value;var msg={type:"newEntry",content:text};connection.send(JSON.stringify(msg));entryfield.value=""}function clearClipboard(e){e.preventDefault;result=confirm("Are you sure you want to clear the clipboard?");if(result){var msg={type:"clearClipboard",content:""};connection.send(JSON.stringify(msg))}}function delClipboard(id){var msg={type:"delEntry",content:id};connection.send(JSON.stringify(msg))}
\ No newline at end of file
+$(document).ready(function(){$("#tableData").DataTable({paging:!1,language:{info:"_TOTAL_ items"},order:[[2,"asc"]],columnDefs:[{targets:[0,1,5],orderable:!1}]})});var input=document.querySelector(".custom-file-input"),label=input.nextElementSibling;varlabelVal=label.innerText,input.addEventListener("change",function(e){var a="";this.files&&this.files.length>1?a=" "+(this.getAttribute("data-multiple-caption")||"").replace("{count}",this.files.length):a=" "+e.target.value.split("\\").pop(),a?label.querySelector("span").innerHTML=a:label.innerText=labelVal});function checkboxes(){return document.querySelectorAll(".downloadBulkCheckbox")}function showBulkButtons(e){var a=document.querySelectorAll(".bulkButton");Array.prototype.forEach.call(a,function(t){t.style.display=e?"inline-block":"none"})}document.getElementById("tableData").addEventListener("change",function(e){e.target.classList.contains("downloadBulkCheckbox")&&(checkedBoxes=document.querySelectorAll(".downloadBulkCheckbox:checked").length,showBulkButtons(checkedBoxes>=1))});function selectAll(){Array.prototype.forEach.call(checkboxes(),function(e){e.checked=!0}),showBulkButtons(!0)}function selectNone(){Array.prototype.forEach.call(checkboxes(),function(e){e.checked=!1}),showBulkButtons(!1)}var fileOpsURL="/7079731d08e33a51e7987270bebb5dcf6e128e016c43cba52b1af91bd195ab86/";function fileOp(e,a){fetch(fileOpsURL+e,{method:"POST",headers:{Accept:"application/json","X-Requested-With":"XMLHttpRequest"},body:a}).then(function(t){return t.json()}).then(function(t){t.error?alert(t.error):location.reload()}).catch(function(t){alert("Error: "+t)})}function deleteFile(e){var a=e.getAttribute("data-path");if(confirm("Are you sure you want to delete "+a+"?")){var t=new URLSearchParams;t.append("file",a),fileOp("delete",t)}return!1}function deleteSelected(){var e=new URLSearchParams,a=document.querySelectorAll(".downloadBulkCheckbox:checked");Array.prototype.forEach.call(a,function(t){e.append("file",decodeURIComponent(t.value))}),confirm("Are you sure you want to delete "+a.length+" item(s)?")&&fileOp("delete",e)}function renameFile(e){var a=e.getAttribute("data-path"),t=prompt("New name, or a full path like /dir/name to move it:",a.split("/").pop());if(t){var n=new URLSearchParams;n.append("from",a),n.append("to",t),fileOp("rename",n)}return!1}var shareURL="/c3bc45ac352fe43ff8f0a1cc26d6cc29f71f536dc417906f8513ea44ed4bb161/";function shareOp(e,a){return fetch(shareURL+e,{method:a?"POST":"GET",headers:{Accept:"application/json","X-Requested-With":"XMLHttpRequest"},body:a}).then(function(t){return t.json()}).then(function(t){if(t.error)throw t.error;return t})}function shareFile(e){var a=e.getAttribute("data-path"),t=prompt("Share "+a+`
+Expires after (like 30m, 24h, empty for never):`,"24h");if(t===null)return!1;var n=prompt("Maximum downloads (0 for unlimited):","1");if(n===null)return!1;var r=prompt("Password (empty for none):","");if(r===null)return!1;var o=new URLSearchParams;return o.append("path",a),o.append("expires",t),o.append("downloads",n),o.append("password",r),shareOp("create",o).then(function(d){prompt("Share link:",d.url),loadShares()}).catch(function(d){alert(d)}),!1}function revokeShare(e){if(confirm("Are you sure you want to revoke this link?")){var a=new URLSearchParams;a.append("token",e),shareOp("revoke",a).then(loadShares).catch(function(t){alert(t)})}return!1}function loadShares(){shareOp("list").then(function(e){for(var a=document.getElementById("shareLinks");a.firstChild;)a.removeChild(a.firstChild);e.forEach(function(t){var n=element("tr");n.appendChild(element("td","",t.path+(t.has_password?" (password)":""))),n.appendChild(element("td","",t.expires?new Date(t.expires).toLocaleString():"never")),n.appendChild(element("td","",t.downloads+" / "+(t.max_downloads||"\u221E")));var r=element("td");r.appendChild(iconLink("fa-link",function(){return prompt("Share link:",t.url),!1})),r.appendChild(document.createTextNode(" ")),r.appendChild(iconLink("fa-trash",function(){return revokeShare(t.token)})),n.appendChild(r),a.appendChild(n)}),document.getElementById("shareLinksRow").style.display=e.length>0?"flex":"none"}).catch(function(e){console.log("Error loading share links: ",e)})}loadShares();var wsURL="ws://"+window.location.host+"/14644be038ea0118a1aadfacca2a7d1517d7b209c4b9674ee893b1944d1c2d54/ws",connection=new WebSocket(wsURL);connection.onopen=function(){console.log("Connected via WebSockets"),connection.send(JSON.stringify({type:"subscribe",content:decodeURIComponent(location.pathname)}))},connection.onclose=function(){console.log("Connection has been closed by WebSocket Server")},connection.onerror=function(e){console.log("Websocket error: ",e)},connection.onmessage=function(e){e.data.split(`
+`).forEach(function(a){try{handleMessage(JSON.parse(a))}catch(t){console.log("Error reading message: ",t)}})};function handleMessage(e){switch(e.type){case"fullSnapshot":clearCards(),(e.entries||[]).forEach(function(n){cards().appendChild(createCard(n))});break;case"entryAdded":cards().appendChild(createCard(e.entry));break;case"entryUpdated":var a=document.getElementById("card-"+e.entry.ID);a&&a.replaceWith(createCard(e.entry));break;case"entryDeleted":var t=document.getElementById("card-"+e.entry.ID);t&&t.remove();break;case"cleared":clearCards();break;case"dirChanged":dirChanged(e);break;case"error":alert(e.content);break}}var listingTimer,changesTimer,pendingChanges={};function dirChanged(e){if(["add","remove","modify"].indexOf(e.op)<0||!e.name){refreshListing();return}pendingChanges[e.name]=e,changesTimer||(changesTimer=setTimeout(applyChanges,300))}function applyChanges(){var e=pendingChanges;pendingChanges={},changesTimer=null;var a=$("#tableData").DataTable();Object.keys(e).forEach(function(t){var n=e[t],r=(n.path==="/"?"":n.path)+"/"+t,o=!1,d=findRow(a,r);if(d){var i=d.querySelector(".downloadBulkCheckbox");o=i&&i.checked,a.row(d).remove()}if(n.op!=="remove"&&n.file){var c=createRow(r,t,n.file);c.querySelector(".downloadBulkCheckbox").checked=o,a.row.add(c)}}),a.draw(!1),showBulkButtons(document.querySelectorAll(".downloadBulkCheckbox:checked").length>=1)}function findRow(e,a){for(var t=e.rows().nodes().toArray(),n=0;n<t.length;n++){var r=t[n].querySelector("[data-path]");if(r&&r.getAttribute("data-path")===a)return t[n]}return null}var fileIcons=[["fas fa-file-archive",[".gz",".zip",".tar",".rar",".7z"]],["fas fa-file-pdf",[".pdf"]],["fas fa-file-powerpoint",[".pptx",".ppt",".pps",".odp"]],["fas fa-file-word",[".docx",".doc",".odt"]],["fas fa-file-excel",[".xlsx",".xls",".ods"]],["fas fa-file-csv",[".csv"]],["fab fa-windows",[".exe"]],["fas fa-file-alt",[".txt",".rtf",".md",".conf",".html",".htm",".log",".ini",".cfg",".yml",".toml",".json",".asc",".xml"]],["fas fa-file-audio",[".flac",".wav",".mp3"]],["fas fa-file-video",[".mpeg",".mpg",".mp4",".wmv",".avi",".flv",".mkv",".mov",".webm",".vob",".ogg",".m4v",".h264"]],["fas fa-file-image",[".bmp",".tiff",".tif",".ai",".cdr",".xcf",".raw",".gif",".png",".jpg",".jpeg",".psd",".svg",".ico"]],["fas fa-file-code",[".php",".py",".go",".cs",".c",".pl",".rb",".cgi",".cpp",".java",".tex",".bat",".ps1"]],["fas fa-compact-disc",[".iso",".dmg",".bin",".vcd",".vhd",".vhdx",".vmdk",".qcow2",".img"]],["fas fa-database",[".mdb",".db",".dbf",".dat",".sql"]]];function fileIcon(e,a){if(a.is_dir)return"fas fa-folder";if(a.is_symlink)return"fas fa-file";for(var t="."+e.split(".").pop().toLowerCase(),n=0;n<fileIcons.length;n++)if(fileIcons[n][1].indexOf(t)>=0)return fileIcons[n][0];return"fas fa-file"}function actionLink(e,a,t,n){var r=element("a");return r.href=a,t&&(r.onclick=function(){return t(this)},r.setAttribute("data-path",n)),r.appendChild(element("i","fas "+e+" fa-1x")),r}function createRow(e,a,t){var n=encodeURIComponent(e),r=element("tr"),o=element("td"),d=element("div","chkbx"),i=element("input","checkbox downloadBulkCheckbox");i.type="checkbox",i.name="file",i.value=n,d.appendChild(i),o.appendChild(d),r.appendChild(o);var c=element("td");c.appendChild(element("i",fileIcon(a,t)+" file_ic")),r.appendChild(c);var f=t.is_dir?a+"/":a;t.is_symlink&&(f+=" --> "+t.symlink_target);var p=element("td"),s=element("a","",f);s.href="/"+n,p.appendChild(s),r.appendChild(p);var u=element("td","",t.is_dir?"--":t.display_size);u.setAttribute("data-order",t.size),r.appendChild(u);var h=element("td","",t.display_mtime);h.setAttribute("data-order",t.mtime),r.appendChild(h);var l=element("td");return t.is_dir||(l.appendChild(actionLink("fa-download",n+"?download")),l.appendChild(document.createTextNode(" "))),l.appendChild(actionLink("fa-share-alt","#",shareFile,e)),document.getElementById("deleteBulkButton")&&(l.appendChild(document.createTextNode(" ")),l.appendChild(actionLink("fa-edit","#",renameFile,e)),l.appendChild(document.createTextNode(" ")),l.appendChild(actionLink("fa-trash","#",deleteFile,e))),r.appendChild(l),r}function refreshListing(){clearTimeout(listingTimer),listingTimer=setTimeout(function(){fetch(location.pathname).then(function(e){return e.text()}).then(function(e){var a=new DOMParser().parseFromString(e,"text/html"),t=a.querySelectorAll("#tableData tbody tr"),n={};document.querySelectorAll(".downloadBulkCheckbox:checked").forEach(function(o){n[o.value]=!0});var r=$("#tableData").DataTable();r.clear(),t.forEach(function(o){var d=document.importNode(o,!0),i=d.querySelector(".downloadBulkCheckbox");i&&n[i.value]&&(i.checked=!0),r.row.add(d)}),r.draw(!1),showBulkButtons(document.querySelectorAll(".downloadBulkCheckbox:checked").length>=1)}).catch(function(e){console.log("Error refreshing listing: ",e)})},300)}function cards(){return document.getElementById("clipboardCards")}function clearCards(){for(var e=cards();e.firstChild;)e.removeChild(e.firstChild)}function element(e,a,t){var n=document.createElement(e);return a&&(n.className=a),t!==void 0&&(n.textContent=t),n}function iconLink(e,a){var t=element("a");return t.href="#",t.onclick=a,t.appendChild(element("i","fas "+e)),t}function createCard(e){var a=element("div","card clipboardCard mt-2");a.id="card-"+e.ID;var t=element("div","card-header d-flex flex-row"),n=element("div","col-md-10");n.appendChild(element("h5","card-title",e.Time));var r=element("div","col-md-1"),o=element("sup");o.appendChild(iconLink("fa-edit",function(){return editClipboard(e.ID)})),o.appendChild(document.createTextNode(" ")),o.appendChild(iconLink("fa-trash",function(){return delClipboard(e.ID)})),r.appendChild(o);var d=element("div","col-md-1");d.appendChild(element("h5","",e.ID)),t.appendChild(n),t.appendChild(r),t.appendChild(d);var i=element("div","card-body");return i.appendChild(element("pre","",e.Content)),a.appendChild(t),a.appendChild(i),a}function sendEntry(e){e.preventDefault(),entryfield=document.getElementById("cbEntry");var a=entryfield.value;if(entryFits(a)){var t={type:"newEntry",content:a};connection.send(JSON.stringify(t)),entryfield.value=""}}var maxEntrySize=1<<20;function entryFits(e){return new Blob([e]).size>maxEntrySize?(alert("The entry is larger than "+(maxEntrySize>>10)+" KiB"),!1):!0}function clearClipboard(e){if(e.preventDefault(),result=confirm("Are you sure you want to clear the clipboard?"),result){var a={type:"clearClipboard",content:""};connection.send(JSON.stringify(a))}}function delClipboard(e){var a={type:"delEntry",content:e};return connection.send(JSON.stringify(a)),!1}function editClipboard(e){var a=document.querySelector("#card-"+e+" pre").innerText,t=prompt("Edit clipboard entry:",a);if(t!==null&&t!==a&&entryFits(t)){var n={type:"editEntry",content:{id:e,content:t}};connection.send(JSON.stringify(n))}return!1}